@@ -0,0 +1,83 @@
+// Copyright 2022 Markus Holmström (MawKKe)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integerintervalexpressions
+
+import (
+	"sync"
+)
+
+// ParseCache memoizes the results of ParseExpressionWithOptions() for a fixed
+// set of ParseOptions, for applications parsing the same inputs repeatedly
+// (e.g from a configuration file re-read on every request). As the results
+// depend on the options, use a separate ParseCache for each set of options.
+// Errors are cached as well. Note that side effects of the options, such as
+// calls to WarnHandler, only happen when an input is parsed for the first
+// time.
+//
+// A ParseCache is safe for concurrent use. The cache is unbounded; use
+// Evict() or Clear() to release entries.
+type ParseCache struct {
+	opts    ParseOptions
+	mu      sync.Mutex
+	entries map[string]parseCacheEntry
+}
+
+type parseCacheEntry struct {
+	expr Expression
+	err  error
+}
+
+// NewParseCache returns an empty ParseCache parsing inputs with opts.
+func NewParseCache(opts ParseOptions) *ParseCache {
+	return &ParseCache{opts: opts, entries: make(map[string]parseCacheEntry)}
+}
+
+// Get returns the result of parsing input with the options of the cache,
+// parsing input only if it is not cached yet.
+func (c *ParseCache) Get(input string) (Expression, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[input]
+	c.mu.Unlock()
+	if ok {
+		return entry.expr, entry.err
+	}
+	// parse without holding the lock; concurrent misses may parse twice
+	expr, err := ParseExpressionWithOptions(input, c.opts)
+	c.mu.Lock()
+	c.entries[input] = parseCacheEntry{expr: expr, err: err}
+	c.mu.Unlock()
+	return expr, err
+}
+
+// Evict removes the cached result for input, if any.
+func (c *ParseCache) Evict(input string) {
+	c.mu.Lock()
+	delete(c.entries, input)
+	c.mu.Unlock()
+}
+
+// Clear removes all cached results.
+func (c *ParseCache) Clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]parseCacheEntry)
+	c.mu.Unlock()
+}
+
+// Len returns the number of cached results.
+func (c *ParseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
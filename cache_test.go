@@ -0,0 +1,83 @@
+// Copyright 2022 Markus Holmström (MawKKe)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integerintervalexpressions
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestParseCache(t *testing.T) {
+	parses := 0
+	opts := DefaultParseOptions()
+	opts.SubExpressionTransformer = func(info SubExpressionInfo) (SubExpressionInfo, error) {
+		parses++
+		return info, nil
+	}
+	cache := NewParseCache(opts)
+
+	for i := 0; i < 3; i++ {
+		expr, err := cache.Get("1-3")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if expr.String() != "1-3" {
+			t.Errorf("expected \"1-3\", got %q", expr)
+		}
+	}
+	if parses != 1 {
+		t.Errorf("expected input to be parsed once, got %d", parses)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get("5-3"); err == nil {
+			t.Errorf("expected error on invalid input")
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 cached results, got %d", cache.Len())
+	}
+
+	cache.Evict("1-3")
+	if _, err := cache.Get("1-3"); err != nil || parses != 2 {
+		t.Errorf("expected evicted input to be parsed again, got %d parses, %v", parses, err)
+	}
+	cache.Clear()
+	if cache.Len() != 0 {
+		t.Errorf("expected empty cache after Clear(), got %d entries", cache.Len())
+	}
+}
+
+func TestParseCacheConcurrent(t *testing.T) {
+	cache := NewParseCache(DefaultParseOptions())
+	inputs := []string{"1", "2-5", "7-", "*"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				input := inputs[j%len(inputs)]
+				if expr, err := cache.Get(input); err != nil || expr.String() != input {
+					t.Errorf("%q: unexpected result %q, %v", input, expr, err)
+				}
+				if j%10 == 0 {
+					cache.Evict(input)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,74 @@
+// Copyright 2022 Markus Holmström (MawKKe)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integerintervalexpressions
+
+// FlagValue adapts an Expression for use as a command line flag. It
+// implements the Value interface of both the standard flag package and
+// github.com/spf13/pflag (without depending on the latter), so that e.g
+//
+//	var pages intervals.Expression
+//	cmd.Flags().Var(intervals.Wrap(&pages), "pages", "page range")
+//
+// parses the flag argument into pages.
+type FlagValue struct {
+	e *Expression
+}
+
+// Wrap returns a FlagValue storing the parsed flag argument in e. The
+// argument is parsed with the ParseOptions of e, or with
+// DefaultParseOptions() if e has none (as is the case for the zero value).
+// The current value of e serves as the default value of the flag. If e is
+// nil, a new Expression is allocated; it is then accessible via Get().
+func Wrap(e *Expression) *FlagValue {
+	if e == nil {
+		e = new(Expression)
+	}
+	return &FlagValue{e: e}
+}
+
+// String returns the textual form of the wrapped Expression.
+func (v *FlagValue) String() string {
+	if v == nil || v.e == nil {
+		return ""
+	}
+	return v.e.String()
+}
+
+// Get returns the wrapped Expression, implementing flag.Getter.
+func (v *FlagValue) Get() interface{} {
+	if v.e == nil {
+		return Expression{}
+	}
+	return *v.e
+}
+
+// Set parses s into the wrapped Expression.
+func (v *FlagValue) Set(s string) error {
+	if v.e == nil {
+		// the zero value FlagValue{} wraps nothing yet
+		v.e = new(Expression)
+	}
+	parsed, err := ParseExpressionWithOptions(s, v.e.reparseOptions())
+	if err != nil {
+		return err
+	}
+	*v.e = parsed
+	return nil
+}
+
+// Type returns the name of the flag value type, shown in pflag usage messages.
+func (v *FlagValue) Type() string {
+	return "interval-expression"
+}
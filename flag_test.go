@@ -0,0 +1,73 @@
+// Copyright 2022 Markus Holmström (MawKKe)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integerintervalexpressions
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestFlagValue(t *testing.T) {
+	var pages Expression
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(Wrap(&pages), "pages", "page range")
+
+	if err := fs.Parse([]string{"-pages", "1,3-5,7-"}); err != nil {
+		t.Fatalf("unexpected error from flag parsing: %s", err)
+	}
+	if !pages.Matches(4) || pages.Matches(6) || pages.String() != "1,3-5,7-" {
+		t.Errorf("unexpected flag value %q", pages)
+	}
+	if err := fs.Parse([]string{"-pages", "5-3"}); err == nil {
+		t.Errorf("expected error on invalid flag value")
+	}
+
+	v := Wrap(&pages)
+	if v.Type() != "interval-expression" {
+		t.Errorf("unexpected type %q", v.Type())
+	}
+	if (&FlagValue{}).String() != "" {
+		t.Errorf("expected empty string from zero value FlagValue")
+	}
+
+	// the options of the wrapped Expression are used for parsing
+	opts := DefaultParseOptions()
+	opts.Delimiter = ";"
+	custom, err := ParseExpressionWithOptions("1", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if err := Wrap(&custom).Set("2;4"); err != nil || custom.String() != "2;4" {
+		t.Errorf("expected \"2;4\", got %q, %v", custom, err)
+	}
+
+	// without a target, the value is only accessible via Get()
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(Wrap(nil), "lines", "line range")
+	if err := fs.Parse([]string{"-lines", "2-4"}); err != nil {
+		t.Fatalf("unexpected error from flag parsing: %s", err)
+	}
+	got, ok := fs.Lookup("lines").Value.(flag.Getter).Get().(Expression)
+	if !ok || got.String() != "2-4" {
+		t.Errorf("expected \"2-4\" from Get(), got %v", got)
+	}
+	var zero FlagValue
+	if err := zero.Set("7"); err != nil || zero.String() != "7" {
+		t.Errorf("expected zero value FlagValue to accept \"7\", got %q, %v", zero.String(), err)
+	}
+}
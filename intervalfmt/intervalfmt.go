@@ -0,0 +1,68 @@
+// Copyright 2022 Markus Holmström (MawKKe)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package intervalfmt provides ready-made ParseOptions for parsing integer
+// interval expressions as they appear in some common application contexts.
+//
+// Each function returns a fresh ParseOptions value, which may be adjusted
+// further before passing it to ParseExpressionWithOptions():
+//
+//	opts := intervalfmt.PrintDialogOptions()
+//	expr, err := intervals.ParseExpressionWithOptions("1,3-5,7-", opts)
+package intervalfmt
+
+import (
+	intervals "github.com/MawKKe/integer-interval-expressions-go"
+)
+
+// CutOptions returns options matching the field/character list syntax of the
+// POSIX `cut` tool, e.g "1,3-5,7-". The list must not be empty, the
+// subexpressions are kept in their original order, and "*" is not accepted.
+//
+// Note that the "-5" form (from start up to 5) accepted by `cut` is not
+// supported by the parser; write it as "1-5" instead.
+func CutOptions() intervals.ParseOptions {
+	return intervals.ParseOptions{
+		Delimiter:            ",",
+		PostProcessNormalize: false,
+		AllowEmptyExpression: false,
+		RejectWildcardStar:   true,
+	}
+}
+
+// PrintDialogOptions returns options matching the page selectors found in
+// print dialogs, e.g "1,3-5,7-". An empty selection is an error (a dialog
+// should rather offer a separate "all pages" choice), and the result is
+// normalized so that each page is matched by exactly one subexpression, in
+// ascending order. "*" is not accepted.
+func PrintDialogOptions() intervals.ParseOptions {
+	return intervals.ParseOptions{
+		Delimiter:            ",",
+		PostProcessNormalize: true,
+		AllowEmptyExpression: false,
+		RejectWildcardStar:   true,
+	}
+}
+
+// CronSubsetOptions returns options for the subset of crontab field syntax
+// consisting of "*", single values, ranges and lists thereof, e.g "1-5,10".
+// Step values ("*/5") and names ("MON") are not supported. The result is
+// normalized, so "*" dominates any other subexpressions in the same field.
+func CronSubsetOptions() intervals.ParseOptions {
+	return intervals.ParseOptions{
+		Delimiter:            ",",
+		PostProcessNormalize: true,
+		AllowEmptyExpression: false,
+	}
+}
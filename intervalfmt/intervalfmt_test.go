@@ -0,0 +1,62 @@
+// Copyright 2022 Markus Holmström (MawKKe)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intervalfmt
+
+import (
+	"testing"
+
+	intervals "github.com/MawKKe/integer-interval-expressions-go"
+)
+
+func TestOptions(t *testing.T) {
+	cases := []struct {
+		name      string
+		opts      intervals.ParseOptions
+		input     string
+		shouldErr bool
+		expect    string
+	}{
+		{name: "cut", opts: CutOptions(), input: "7-,1,3-5", expect: "7-,1,3-5"},
+		{name: "cut-empty", opts: CutOptions(), input: "", shouldErr: true},
+		{name: "cut-invalid", opts: CutOptions(), input: "-5", shouldErr: true},
+		{name: "cut-star", opts: CutOptions(), input: "*", shouldErr: true},
+		{name: "cut-leading-zeros", opts: CutOptions(), input: "01-05", expect: "1-5"},
+		{name: "print-dialog", opts: PrintDialogOptions(), input: "5-7,1,2-3", expect: "1-3,5-7"},
+		{name: "print-dialog-empty", opts: PrintDialogOptions(), input: "", shouldErr: true},
+		{name: "print-dialog-star", opts: PrintDialogOptions(), input: "1,*", shouldErr: true},
+		{name: "print-dialog-leading-zeros", opts: PrintDialogOptions(), input: "01-05,007", expect: "1-5,7"},
+		{name: "cron", opts: CronSubsetOptions(), input: "10,1-5", expect: "1-5,10"},
+		{name: "cron-star", opts: CronSubsetOptions(), input: "1-5,*", expect: "*"},
+		{name: "cron-step", opts: CronSubsetOptions(), input: "*/5", shouldErr: true},
+		{name: "cron-leading-zeros", opts: CronSubsetOptions(), input: "05,00-02", expect: "0-2,5"},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := intervals.ParseExpressionWithOptions(test.input, test.opts)
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error from parser: %s", err)
+			}
+			if got := expr.String(); got != test.expect {
+				t.Fatalf("expected: %q, got: %q", test.expect, got)
+			}
+		})
+	}
+}
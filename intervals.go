@@ -28,11 +28,23 @@
 package integerintervalexpressions
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"net"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // subExpression represents a single continuous interval
@@ -40,21 +52,139 @@ type subExpression struct {
 	start    int
 	count    int
 	matchAll bool
+	negate   bool          // excludes the values instead, see ParseOptions.AllowNegation
+	notation valueNotation // how the values were written in the input
 }
 
 func (se subExpression) String() string {
+	if se.negate {
+		positive := se
+		positive.negate = false
+		return "^" + positive.String()
+	}
 	if se.matchAll {
 		return "*"
 	}
 
 	switch se.count {
 	case 0:
-		return fmt.Sprintf("%d-", se.start)
+		return fmt.Sprintf("%s-", se.notation.format(se.start))
 	case 1:
-		return fmt.Sprintf("%d", se.start)
+		return se.notation.format(se.start)
+	default:
+		return fmt.Sprintf("%s-%s", se.notation.format(se.start), se.notation.format(se.start+se.count-1))
+	}
+}
+
+// SubExpressionInfo describes a single subexpression of an Expression.
+type SubExpressionInfo struct {
+	Start     int  // the first value matched; zero if MatchAll
+	End       int  // the last value matched; zero if Unbounded or MatchAll
+	Unbounded bool // the subexpression is half-open, e.g "7-"
+	MatchAll  bool // the subexpression is "*"
+	Negated   bool // the subexpression excludes values, e.g "^5"
+}
+
+// subExpressionFromInfo is the inverse of subExpression.info()
+func subExpressionFromInfo(info SubExpressionInfo) (subExpression, error) {
+	switch {
+	case info.MatchAll:
+		return subExpression{matchAll: true, negate: info.Negated}, nil
+	case info.Unbounded:
+		return subExpression{start: info.Start, count: 0, negate: info.Negated}, nil
+	case info.End < info.Start:
+		return subExpression{}, fmt.Errorf("invalid interval where start > end: %d > %d", info.Start, info.End)
+	}
+	count := info.End - info.Start + 1
+	if count <= 0 {
+		return subExpression{}, fmt.Errorf("interval too large: %d-%d", info.Start, info.End)
+	}
+	return subExpression{start: info.Start, count: count, negate: info.Negated}, nil
+}
+
+// Interval is a bounded range of integers, from Start to End (inclusive).
+type Interval struct {
+	Start int
+	End   int
+}
+
+// Count returns the number of integers in the Interval.
+func (i Interval) Count() int {
+	return i.End - i.Start + 1
+}
+
+// SortFunc is a less-than relation between two subexpressions.
+type SortFunc func(a, b SubExpressionInfo) bool
+
+func (se subExpression) info() SubExpressionInfo {
+	switch {
+	case se.matchAll:
+		return SubExpressionInfo{MatchAll: true, Negated: se.negate}
+	case se.count == 0:
+		return SubExpressionInfo{Start: se.start, Unbounded: true, Negated: se.negate}
 	default:
-		return fmt.Sprintf("%d-%d", se.start, se.start+se.count-1)
+		return SubExpressionInfo{Start: se.start, End: se.start + se.count - 1, Negated: se.negate}
+	}
+}
+
+// matches determines whether val lies in the interval (ignoring negation)
+func (se subExpression) matches(val int) bool {
+	return se.matchAll || (val >= se.start && (se.count == 0 || val <= (se.start+se.count-1)))
+}
+
+// contains determines whether every value matched by o is matched by se
+func (se subExpression) contains(o subExpression) bool {
+	if se.matchAll {
+		return true
+	}
+	if o.matchAll || o.start < se.start {
+		return false
+	}
+	if se.count == 0 {
+		return true
+	}
+	return o.count != 0 && o.start+o.count <= se.start+se.count
+}
+
+// overlaps determines whether se and o match any value in common
+func (se subExpression) overlaps(o subExpression) bool {
+	if se.matchAll || o.matchAll {
+		return true
+	}
+	if o.start < se.start {
+		se, o = o, se
+	}
+	return se.count == 0 || o.start < se.start+se.count
+}
+
+// clip returns the part [a, b] of the closed range [lo, hi] matched by se
+// (ignoring negation); if there is none, a > b.
+func (se subExpression) clip(lo, hi int) (a, b int) {
+	a, b = lo, hi
+	if !se.matchAll {
+		if se.start > a {
+			a = se.start
+		}
+		if se.count != 0 && se.start+se.count-1 < b {
+			b = se.start + se.count - 1
+		}
+	}
+	return a, b
+}
+
+// valueNotation records the base (and prefix) in which the values of a
+// subExpression were written, so that they can be serialized back in the same
+// notation. The zero value stands for plain decimal.
+type valueNotation struct {
+	base   int
+	prefix string
+}
+
+func (n valueNotation) format(v int) string {
+	if n.base == 0 {
+		return strconv.Itoa(v)
 	}
+	return n.prefix + strconv.FormatInt(int64(v), n.base)
 }
 
 // Expression is an abstract type containing a sequence of subexpressions
@@ -66,6 +196,20 @@ func (se subExpression) String() string {
 type Expression struct {
 	intervals []subExpression
 	opts      ParseOptions // original options used for parsing this Expression
+	warnings  []string     // non-fatal issues detected while parsing
+	negated   bool         // whether intervals contains negated subexpressions
+	isSorted  bool         // whether intervals are ordered by start; see Matches()
+}
+
+// Warnings returns the non-fatal issues the parser detected in the input
+// string, such as exceeding ParseOptions.IntervalCountWarning, or redundant
+// subexpressions (duplicates, subexpressions contained in others, and
+// overlapping ones; each redundant subexpression is reported once). The
+// Expression is fully usable regardless; the warnings are meant for informing
+// users that their input could be simplified. Warnings are not carried over
+// to Expressions derived from this one (e.g via Normalize()).
+func (e Expression) Warnings() []string {
+	return e.warnings
 }
 
 // MatchesNone determines whether the Expression will ever match anything.
@@ -76,13 +220,25 @@ type Expression struct {
 // such input expressions by setting ParseOptions.AllowEmptyExpression to
 // false; the current default options also have this field set as false (see
 // DefaultParseOptions()).
+//
+// Note that negated subexpressions (see ParseOptions.AllowNegation) alone do
+// not match anything.
 func (e Expression) MatchesNone() bool {
-	return len(e.intervals) == 0
+	for _, itv := range e.intervals {
+		if !itv.negate {
+			return false
+		}
+	}
+	return true
 }
 
 // MatchesAll determines whether the Expression will match every possible input
 // i.e if MatchesAll() == true; then Matches(x) == true for all x.
 func (e Expression) MatchesAll() bool {
+	if e.negated {
+		// the exclusions would need to be evaluated; see Normalize()
+		return false
+	}
 	for _, sub := range e.intervals {
 		if sub.matchAll {
 			return true
@@ -91,6 +247,26 @@ func (e Expression) MatchesAll() bool {
 	return false
 }
 
+// positive returns an equivalent Expression without negated subexpressions.
+// Methods that inspect the subexpressions directly (rather than via Matches)
+// operate on this form.
+func (e Expression) positive() Expression {
+	if !e.negated {
+		return e
+	}
+	return e.Normalize()
+}
+
+// excludes determines whether val is excluded by a negated subexpression
+func (e Expression) excludes(val int) bool {
+	for _, itv := range e.intervals {
+		if itv.negate && itv.matches(val) {
+			return true
+		}
+	}
+	return false
+}
+
 // Matches determines whether an integer is contained within the intervals expression
 //
 // For example, given
@@ -106,14 +282,106 @@ func (e Expression) MatchesAll() bool {
 //
 // This method does not require the Expression to be normalized, although
 // normalized instances *should* allow for quicker evaluation due to reduced
-// number of interval elements in the Expression; see .Normalize().
+// number of interval elements in the Expression; see .Normalize(). The
+// subexpressions of a normalized Expression are also ordered, so the search
+// stops as soon as it passes val.
+//
+// Negative values are handled like any other: the parser currently only
+// produces non-negative intervals, so Matches(x) for x < 0 is true only for
+// expressions containing "*". Should an Expression contain intervals with
+// negative bounds (e.g '-5--1'), Matches(-3) evaluates to true as expected.
+//
+// Values lying in a negated subexpression (see ParseOptions.AllowNegation)
+// never match, regardless of the other subexpressions.
 func (e Expression) Matches(val int) bool {
 	for _, itv := range e.intervals {
+		if itv.negate {
+			continue
+		}
 		if itv.matchAll {
-			return true
+			return !e.negated || !e.excludes(val)
 		}
 		if val >= itv.start {
 			if itv.count == 0 || val <= (itv.start+itv.count-1) {
+				return !e.negated || !e.excludes(val)
+			}
+		} else if e.isSorted {
+			// the remaining subexpressions all start after val
+			break
+		}
+	}
+	return false
+}
+
+// Contains is an alias of Matches(n), for code that reads more naturally in
+// terms of set membership, e.g allowedLines.Contains(lineNum).
+func (e Expression) Contains(n int) bool {
+	return e.Matches(n)
+}
+
+// ContainsPoint is an alias of Matches(n); see also Contains().
+func (e Expression) ContainsPoint(n int) bool {
+	return e.Matches(n)
+}
+
+// MatchesWithContext is like Matches, but stops scanning the intervals and
+// returns ctx.Err() if ctx is done before the result has been determined.
+// Once the scan completes, the result is returned with a nil error, even if
+// ctx is done by then. Mostly useful for Expressions consisting of a huge
+// number of intervals.
+func (e Expression) MatchesWithContext(ctx context.Context, val int) (bool, error) {
+	// negated subexpressions are evaluated in the same scan rather than
+	// resolved via Normalize(), which could not be interrupted
+	matched := false
+	for i, itv := range e.intervals {
+		// checking the context is relatively expensive; do it only every now and then
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+		}
+		if !itv.matches(val) {
+			continue
+		}
+		if itv.negate {
+			return false, nil
+		}
+		if !e.negated {
+			return true, nil
+		}
+		matched = true
+	}
+	return matched, nil
+}
+
+// MatchesWithFallback is like Matches(), except that it returns fallback if
+// the Expression has no subexpressions at all, as is the case for the zero
+// value Expression{} (e.g from a parse error that was ignored) and for empty
+// input parsed with ParseOptions.AllowEmptyExpression.
+func (e Expression) MatchesWithFallback(val int, fallback bool) bool {
+	if len(e.intervals) == 0 {
+		return fallback
+	}
+	return e.Matches(val)
+}
+
+// MatchesRange determines whether any integer in the closed range [lo, hi] is
+// contained within the intervals expression. For example, given '1,7-' the
+// range [2, 6] does not match, while [2, 7] does. An empty range (lo > hi)
+// never matches.
+//
+// The result is equivalent to testing each value in the range with Matches(),
+// but is computed by comparing interval endpoints directly.
+func (e Expression) MatchesRange(lo, hi int) bool {
+	if lo > hi {
+		return false
+	}
+	for _, itv := range e.positive().intervals {
+		if itv.matchAll {
+			return true
+		}
+		if itv.start <= hi {
+			if itv.count == 0 || (itv.start+itv.count-1) >= lo {
 				return true
 			}
 		}
@@ -121,6 +389,244 @@ func (e Expression) Matches(val int) bool {
 	return false
 }
 
+// MatchesSegment is an alias of MatchesRange(lo, hi): it determines whether
+// any integer in the closed interval [lo, hi] is contained within the
+// intervals expression.
+func (e Expression) MatchesSegment(lo, hi int) bool {
+	return e.MatchesRange(lo, hi)
+}
+
+// MatchesAllOf determines whether every given value is contained within the
+// intervals expression, i.e whether Matches(v) == true for each v. The check
+// stops at the first non-matching value. Given no values, the result is true.
+//
+// Not to be confused with MatchesAll(), which tells whether the Expression
+// matches every possible integer.
+func (e Expression) MatchesAllOf(vals ...int) bool {
+	for _, val := range vals {
+		if !e.Matches(val) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesAnyOf determines whether at least one of the given values is
+// contained within the intervals expression. The check stops at the first
+// matching value. Given no values, the result is false.
+func (e Expression) MatchesAnyOf(vals ...int) bool {
+	for _, val := range vals {
+		if e.Matches(val) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesMany returns the number of values in vals that are contained within
+// the intervals expression.
+func (e Expression) MatchesMany(vals []int) int {
+	n := 0
+	for _, val := range vals {
+		if e.Matches(val) {
+			n++
+		}
+	}
+	return n
+}
+
+// MatchesIndexedMap returns a new map containing only those entries of m
+// whose key is contained within the intervals expression. The input map is
+// not modified.
+func (e Expression) MatchesIndexedMap(m map[int]string) map[int]string {
+	res := make(map[int]string)
+	for k, v := range m {
+		if e.Matches(k) {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// MatchesRune determines whether the Unicode code point r is contained within
+// the intervals expression. See also ParseRuneRangeExpression().
+func (e Expression) MatchesRune(r rune) bool {
+	return e.Matches(int(r))
+}
+
+// MatchesTimestamp determines whether the Unix timestamp ts (in seconds) is
+// contained within the intervals expression. See also
+// ParseTimestampExpression().
+//
+// See MatchesInt64() for how timestamps that do not fit into an int on the
+// current platform are handled.
+func (e Expression) MatchesTimestamp(ts int64) bool {
+	return e.MatchesInt64(ts)
+}
+
+// MatchesInt64 determines whether the int64 value val is contained within the
+// intervals expression. Unlike Matches(int(val)), the value is never
+// truncated: on platforms where int is narrower than int64, values outside
+// the range of int are larger (or smaller) than any interval bound, and can
+// thus only be matched by "*" and (for large values) half-open subexpressions.
+func (e Expression) MatchesInt64(val int64) bool {
+	if v := int(val); int64(v) == val {
+		return e.Matches(v)
+	}
+	e = e.positive()
+	if val > 0 {
+		return e.matchesAboveMaxInt()
+	}
+	return e.MatchesAll()
+}
+
+// Matches16 determines whether the int16 value val is contained within the
+// intervals expression. Every int16 value fits into an int, so this is
+// equivalent to Matches(int(val)).
+func (e Expression) Matches16(val int16) bool {
+	return e.Matches(int(val))
+}
+
+// Matches8 determines whether the int8 value val is contained within the
+// intervals expression. This is equivalent to Matches(int(val)); note that
+// negative values are sign extended, so e.g int8(-1) is never matched by an
+// expression written in the input syntax. Convert unsigned bytes with
+// Matches(int(b)) instead.
+func (e Expression) Matches8(val int8) bool {
+	return e.Matches(int(val))
+}
+
+// MatchesUint determines whether the unsigned integer val is contained within
+// the intervals expression. Values larger than math.MaxInt can only be matched
+// by "*" and half-open subexpressions.
+func (e Expression) MatchesUint(val uint) bool {
+	if val > math.MaxInt {
+		return e.matchesAboveMaxInt()
+	}
+	return e.Matches(int(val))
+}
+
+// matchesAboveMaxInt determines whether the Expression matches values that are
+// too large to be represented as int.
+func (e Expression) matchesAboveMaxInt() bool {
+	for _, itv := range e.positive().intervals {
+		if itv.matchAll || itv.count == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesSliceIndices treats the Expression as a selector of 1-based positions
+// in s (like the page numbers of a document), and returns the 0-based indices
+// of the selected elements, in ascending order. For example, given '1,3,5-'
+// and a slice of 6 elements, the result is [0, 2, 4, 5]. The values of the
+// elements themselves are irrelevant.
+func (e Expression) MatchesSliceIndices(s []int) []int {
+	var indices []int
+	for i := range s {
+		if e.Matches(i + 1) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// MatchesRunSlice returns, in the original order, those runs that have at
+// least one value in common with the intervals expression. Each run covers
+// the Count consecutive integers beginning at Start, as in run-length encoded
+// data; runs with a non-positive Count are empty and never match. The input
+// slice is not modified.
+func (e Expression) MatchesRunSlice(runs []struct{ Start, Count int }) []struct{ Start, Count int } {
+	var res []struct{ Start, Count int }
+	for _, run := range runs {
+		if run.Count > 0 && e.MatchesRange(run.Start, run.Start+run.Count-1) {
+			res = append(res, run)
+		}
+	}
+	return res
+}
+
+// MatchesCSVLine parses line as a single record of comma-separated values
+// (with quoting as in encoding/csv), and returns those fields whose 1-based
+// position is contained within the intervals expression, in their original
+// order; like `cut -d, -f` but with support for quoted fields. If line is
+// not a valid CSV record, the result is nil.
+func (e Expression) MatchesCSVLine(line string) []string {
+	r := csv.NewReader(strings.NewReader(line))
+	r.FieldsPerRecord = -1
+	record, err := r.Read()
+	if err != nil {
+		return nil
+	}
+	var fields []string
+	for i, field := range record {
+		if e.Matches(i + 1) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// MatchesCIDR determines whether the intervals expression matches any of the
+// addresses in the CIDR block cidr (e.g "192.168.1.0/24"), with the addresses
+// numbered by their offset from base. With base 192.168.1.0 (or nil, meaning
+// the network address of the block) the block above thus spans the integers
+// 0-255, while with base 192.168.0.0 it spans 256-511. The result is false if
+// cidr is invalid, or if base is not an address of the same family.
+func (e Expression) MatchesCIDR(cidr string, base net.IP) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	if base == nil {
+		base = network.IP
+	}
+	if len(network.IP) == net.IPv4len {
+		base = base.To4()
+	} else if base.To4() != nil {
+		base = nil
+	}
+	if base == nil {
+		return false
+	}
+	ones, bits := network.Mask.Size()
+	lo := new(big.Int).Sub(new(big.Int).SetBytes(network.IP), new(big.Int).SetBytes(base))
+	hi := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	hi.Add(hi, lo).Sub(hi, big.NewInt(1))
+
+	maxInt, minInt := big.NewInt(math.MaxInt), big.NewInt(math.MinInt)
+	switch {
+	case lo.Cmp(maxInt) > 0:
+		return e.matchesAboveMaxInt()
+	case hi.Cmp(minInt) < 0:
+		return e.positive().MatchesAll()
+	}
+	loVal, hiVal := math.MinInt, math.MaxInt
+	if lo.Cmp(minInt) > 0 {
+		loVal = int(lo.Int64())
+	}
+	if hi.Cmp(maxInt) < 0 {
+		hiVal = int(hi.Int64())
+	}
+	return e.MatchesRange(loVal, hiVal)
+}
+
+// MatchesSeq returns a sequence yielding those values of seq that are
+// contained within the intervals expression, in their original order. The
+// sequences have the type underlying iter.Seq[int] from Go 1.23, so that
+// MatchesSeq composes with iter.Seq transformers (and range-over-func loops)
+// while this module still supports older Go versions:
+//
+//	for v := range expr.MatchesSeq(slices.Values(vals)) { ... }
+func (e Expression) MatchesSeq(seq func(yield func(int) bool)) func(yield func(int) bool) {
+	return func(yield func(int) bool) {
+		seq(func(v int) bool {
+			return !e.Matches(v) || yield(v)
+		})
+	}
+}
+
 // ParseOptions adjusts how the ParseExpression function will interpret the input
 type ParseOptions struct {
 	Delimiter            string
@@ -131,10 +637,187 @@ type ParseOptions struct {
 	// If true, empty input will result in Expression that will match nothing.
 	AllowEmptyExpression bool
 
+	// Soft limit for the number of subexpressions in the input. If the input
+	// contains more subexpressions than this, parsing succeeds but a warning
+	// is recorded in the resulting Expression (see Expression.Warnings()).
+	// Zero disables the check.
+	IntervalCountWarning int
+
+	// Treat expressions that match every non-negative integer, i.e the whole
+	// range [0, math.MaxInt] (e.g "0-" or "0-5,3-"), as if they were "*", so
+	// that MatchesAll() reports true for them. Without PostProcessNormalize
+	// the subexpressions are kept as is, unless together they match every
+	// non-negative integer, in which case they are replaced by a single "*".
+	// Normalize() applies the same rule.
+	AutoExtendToMatchAll bool
+
+	// The base in which integer values are written, between 2 and 36. Zero
+	// means base 10. Set to IntBaseAuto to detect the base of each value from
+	// its prefix, as in Go integer literals: "0x1f" (hexadecimal), "0b101"
+	// (binary), "0o17" and "017" (octal), otherwise decimal. Expression.String()
+	// writes the values back in the base (and prefix) of the interval start.
+	IntBase int
+
+	// The version of the expression syntax the input is written in. Zero
+	// means CurrentExpressionVersion. Versions newer than the one supported
+	// by this library are rejected with an error. Should the syntax change
+	// in an incompatible way, older versions will be parsed in legacy mode.
+	ExpressionVersion int
+
+	// If non-nil, the parser calls WarnHandler with each warning it reports
+	// in Expression.Warnings(), such as those about redundant subexpressions.
+	// Warnings are not errors; the handler is simply informed about them.
+	WarnHandler func(msg string)
+
+	// If non-nil, the parsed subexpressions are reordered with a stable sort
+	// using this less-than function (after normalization, if enabled). The
+	// order has no effect on which values the Expression matches, but it
+	// determines the output of Expression.String().
+	SubExpressionSort SortFunc
+
+	// Accept values with an explicit leading plus sign, e.g "+5" for "5".
+	// The sign is not retained in Expression.String().
+	AllowPrefixPlus bool
+
+	// Reject the subexpression "*" ("match everything") with an error. Useful
+	// when "*" means something else (e.g a glob) in the application context.
+	RejectWildcardStar bool
+
+	// If non-nil, each subexpression is passed to this function right after
+	// it has been parsed, and is replaced by the returned description. If the
+	// function returns an error, parsing fails with that error. Note that the
+	// result must describe a valid subexpression (End >= Start).
+	SubExpressionTransformer func(SubExpressionInfo) (SubExpressionInfo, error)
+
+	// Accept negated subexpressions, prefixed with "^", which exclude values
+	// from the Expression. For example "1-10,^5" matches 1-4 and 6-10.
+	// Exclusions take precedence over all other subexpressions, regardless of
+	// their order. Normalize() resolves the exclusions, producing an
+	// Expression without negated subexpressions.
+	AllowNegation bool
+
+	// If non-nil, and the input contains no subexpressions (e.g "" or ",,")
+	// while AllowEmptyExpression is true, the parser returns this Expression
+	// as is instead of one that matches nothing. For example, set it to a
+	// parsed "*" to make missing input match every value.
+	FallbackExpression *Expression
+
+	// Convert panics in the user-supplied hooks (SubExpressionTransformer,
+	// SubExpressionSort, ErrorFactory and WarnHandler) into parse errors,
+	// instead of letting them propagate to the caller. Panics elsewhere in
+	// the parser are not affected.
+	RecoverFromPanic bool
+
+	// Treat the input as 1-based (as are e.g page numbers in a print dialog)
+	// and shift the values down by one, so that the Expression matches the
+	// corresponding 0-based indices: with "1-3", Matches(0) is true and
+	// Matches(3) is false. Since 0 has no 1-based counterpart, it is rejected
+	// in the input. Expression.String() shifts the values back up by one.
+	ZeroBased bool
+
+	// An IETF BCP 47 language tag (e.g "de" or "de-DE") selecting the digit
+	// grouping separator accepted in decimal values, so that "1.000-2.000"
+	// can be parsed as 1000-2000 in a German locale. Only the primary
+	// language subtag is considered, and only languages using "." (e.g "de",
+	// "es", "it") or "," (e.g "en", "ja", "zh") as the separator are
+	// supported. The separator must differ from Delimiter. Values are
+	// written without separators in Expression.String(). Empty means no
+	// digit grouping.
+	Locale string
+
+	// Reject ranges consisting of a single value, such as "5-5", requiring
+	// them to be written as single values ("5") instead. Useful for linting
+	// configuration files.
+	RejectSingletonAsRange bool
+
+	// Accept parenthesized groups of subexpressions, such as "(1-3),(5,7-9)".
+	// The contents of a group are parsed as an expression of their own, and
+	// its subexpressions become part of the enclosing expression; groups may
+	// be nested. As expressions are unions of their subexpressions, grouping
+	// does not change which values are matched. Groups cannot be negated.
+	AllowNested bool
+
+	// If non-nil, the input is split into subexpressions on the matches of
+	// this regular expression, and Delimiter is ignored by the parser. The
+	// regexp must not match within subexpressions (e.g "1-2"), which is
+	// checked before parsing. Note that Expression.String() still joins the
+	// subexpressions with Delimiter (or "," if empty), so set it to a string
+	// matched by the regexp for the textual form to round-trip.
+	DelimiterRegexp *regexp.Regexp
+
+	// If the input contains "*", drop the other subexpressions, which are
+	// redundant (e.g "1-3,*" becomes "*"). This is the only simplification
+	// applied, making it a lightweight alternative to PostProcessNormalize.
+	// Negated subexpressions are kept, as they still exclude values.
+	StripRedundantWildcard bool
+
+	// Reject values with leading zeros, such as "007", as ambiguous, since
+	// in some contexts (e.g C) they denote octal numbers. By default they are
+	// accepted and parsed as decimal ("007" is 7), as the parser always has.
+	// "0" itself is always accepted, and with IntBase set to IntBaseAuto a
+	// leading zero denotes octal regardless.
+	RejectLeadingZeros bool
+
+	// If non-nil, the parser calls ErrorFactory to construct the errors
+	// caused by invalid input, instead of using its own error messages. The
+	// kind identifies the category of the error, token is the offending part
+	// of the input (usually a subexpression), and pos is the byte offset of
+	// token in the input. Errors caused by invalid ParseOptions are not
+	// affected.
+	ErrorFactory func(kind ErrorKind, token string, pos int) error
+
 	//openEnd bool // 1-3 stands for 1,2,3 or 1,2?
 	//greedy  bool // 2-4,2,2- -> which is actually dominant?
 }
 
+// ErrorKind identifies the category of a parse error; see
+// ParseOptions.ErrorFactory.
+type ErrorKind int
+
+const (
+	// ErrorKindInvalidSyntax denotes input that is not a valid subexpression,
+	// e.g "1-2-3" or unbalanced parentheses.
+	ErrorKindInvalidSyntax ErrorKind = iota
+
+	// ErrorKindInvalidValue denotes a value that can not be parsed as an
+	// integer with the current options, e.g "1a" in base 10.
+	ErrorKindInvalidValue
+
+	// ErrorKindInvalidRange denotes a range whose start is greater than its
+	// end, e.g "5-3".
+	ErrorKindInvalidRange
+
+	// ErrorKindProhibited denotes valid syntax prohibited by the current
+	// options, e.g "*" when ParseOptions.RejectWildcardStar is set.
+	ErrorKindProhibited
+
+	// ErrorKindEmptyExpression denotes empty input when
+	// ParseOptions.AllowEmptyExpression is false.
+	ErrorKindEmptyExpression
+
+	// ErrorKindRejected denotes a subexpression rejected by
+	// ParseOptions.SubExpressionTransformer.
+	ErrorKindRejected
+)
+
+// errorf constructs a parse error with opts.ErrorFactory if set, and with
+// fmt.Errorf(format, args...) otherwise.
+func (opts ParseOptions) errorf(kind ErrorKind, token string, pos int, format string, args ...interface{}) error {
+	if opts.ErrorFactory != nil {
+		var err error
+		opts.guardHook(func() { err = opts.ErrorFactory(kind, token, pos) })
+		return err
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// CurrentExpressionVersion is the newest expression syntax version supported
+// by the parser; see ParseOptions.ExpressionVersion.
+const CurrentExpressionVersion = 1
+
+// IntBaseAuto is a special value for ParseOptions.IntBase; see its description.
+const IntBaseAuto = -1
+
 // DefaultParseOptions returns some sensible set of options for default usage.
 func DefaultParseOptions() ParseOptions {
 	return ParseOptions{
@@ -146,36 +829,121 @@ func DefaultParseOptions() ParseOptions {
 	}
 }
 
+// Merge returns a copy of opts in which every field that is set in override
+// is replaced by the value from override. A field counts as set if it is not
+// the zero value of its type:
+//
+//   - Delimiter and Locale: non-empty strings. (An empty Delimiter is invalid
+//     anyway, so "not set" is the only sensible interpretation.)
+//   - IntBase, ExpressionVersion and IntervalCountWarning: non-zero integers.
+//     Note that zero is a valid value for each of these (meaning base 10,
+//     the current version, and no warning, respectively), so an override can
+//     not reset them back to zero.
+//   - The bool fields: true. An override can thus enable options, but not
+//     disable options enabled in opts; for example, PostProcessNormalize can
+//     not be turned off this way.
+//   - The function and pointer fields: non-nil.
+func (opts ParseOptions) Merge(override ParseOptions) ParseOptions {
+	res := opts
+	if override.Delimiter != "" {
+		res.Delimiter = override.Delimiter
+	}
+	res.PostProcessNormalize = res.PostProcessNormalize || override.PostProcessNormalize
+	res.AllowEmptyExpression = res.AllowEmptyExpression || override.AllowEmptyExpression
+	if override.IntervalCountWarning != 0 {
+		res.IntervalCountWarning = override.IntervalCountWarning
+	}
+	res.AutoExtendToMatchAll = res.AutoExtendToMatchAll || override.AutoExtendToMatchAll
+	if override.IntBase != 0 {
+		res.IntBase = override.IntBase
+	}
+	if override.ExpressionVersion != 0 {
+		res.ExpressionVersion = override.ExpressionVersion
+	}
+	if override.WarnHandler != nil {
+		res.WarnHandler = override.WarnHandler
+	}
+	if override.SubExpressionSort != nil {
+		res.SubExpressionSort = override.SubExpressionSort
+	}
+	res.AllowPrefixPlus = res.AllowPrefixPlus || override.AllowPrefixPlus
+	res.RejectWildcardStar = res.RejectWildcardStar || override.RejectWildcardStar
+	if override.SubExpressionTransformer != nil {
+		res.SubExpressionTransformer = override.SubExpressionTransformer
+	}
+	res.AllowNegation = res.AllowNegation || override.AllowNegation
+	if override.FallbackExpression != nil {
+		res.FallbackExpression = override.FallbackExpression
+	}
+	res.RecoverFromPanic = res.RecoverFromPanic || override.RecoverFromPanic
+	res.ZeroBased = res.ZeroBased || override.ZeroBased
+	if override.Locale != "" {
+		res.Locale = override.Locale
+	}
+	res.RejectSingletonAsRange = res.RejectSingletonAsRange || override.RejectSingletonAsRange
+	res.AllowNested = res.AllowNested || override.AllowNested
+	if override.ErrorFactory != nil {
+		res.ErrorFactory = override.ErrorFactory
+	}
+	if override.DelimiterRegexp != nil {
+		res.DelimiterRegexp = override.DelimiterRegexp
+	}
+	res.StripRedundantWildcard = res.StripRedundantWildcard || override.StripRedundantWildcard
+	res.RejectLeadingZeros = res.RejectLeadingZeros || override.RejectLeadingZeros
+	return res
+}
+
 // Normalize reduces overlapping expressions to minimum set of intervals;
 // some new interval elements may be totally new, while others are dropped.
 // For example, expression '1-4,2-5' should normalize to '1-5'.
 // The method returns a new normalized Expression derived from the current
 // one.
 func (e Expression) Normalize() Expression {
+	// negated subexpressions are resolved by subtracting them from the others,
+	// which already produces the minimal set of intervals. Like the other set
+	// operations, this leaves out negative values, so that e.g '*,^5' becomes
+	// '0-4,6-' rather than an unparseable range starting from math.MinInt.
+	if e.negated {
+		pos := Expression{opts: e.opts}
+		var neg Expression
+		for _, itv := range e.intervals {
+			if itv.negate {
+				itv.negate = false
+				neg.intervals = append(neg.intervals, itv)
+			} else {
+				pos.intervals = append(pos.intervals, itv)
+			}
+		}
+		return Subtract(pos, neg)
+	}
+
 	// short-circuit by empty expression
-	// no need to do anything, just return the existing expression
+	// no need to do anything, just return (a copy of) the existing expression
 	if e.MatchesNone() {
-		return e
+		return Expression{intervals: e.intervals, opts: e.opts}
 	}
 
 	// short-circuit by "*"
 	// TODO MatchesAll() loops the intervals, but so do we below. Figure out a
 	// way to integrate this check into the main loop below?
 	if e.MatchesAll() {
-		return Expression{intervals: []subExpression{{matchAll: true}}, opts: e.opts}
+		return Expression{intervals: []subExpression{{matchAll: true}}, opts: e.opts, isSorted: true}
 	}
 
-	// this code assumes that now intervals are ordered by start value
-	sort.Slice(e.intervals, func(a int, b int) bool {
-		return e.intervals[a].start < e.intervals[b].start
+	// this code assumes that now intervals are ordered by start value. Sort a
+	// copy, so that the receiver (and its String() form) is left untouched.
+	sorted := make([]subExpression, len(e.intervals))
+	copy(sorted, e.intervals)
+	sort.Slice(sorted, func(a int, b int) bool {
+		return sorted[a].start < sorted[b].start
 	})
 
 	var norm []subExpression
 
-	current := e.intervals[0]
+	current := sorted[0]
 
-	for i := 1; i < len(e.intervals); i++ {
-		next := e.intervals[i]
+	for i := 1; i < len(sorted); i++ {
+		next := sorted[i]
 		if current.count == 0 {
 			// extends to infinity, we can skip
 			break
@@ -190,9 +958,12 @@ func (e Expression) Normalize() Expression {
 				current.count = 0
 				break
 			} else {
-				// next is absorbed into current
+				// next is absorbed into current; it may also be wholly
+				// contained in current, in which case nothing changes
 				nextEnd := next.start + next.count - 1
-				current.count = nextEnd - current.start + 1
+				if nextEnd > currentEnd {
+					current.count = nextEnd - current.start + 1
+				}
 			}
 		} else {
 			// next interval is outside/non-adjacent to currentent
@@ -204,39 +975,1058 @@ func (e Expression) Normalize() Expression {
 		}
 	}
 	norm = append(norm, current)
-	return Expression{intervals: norm, opts: e.opts}
+
+	// '0-' covers every value the parser can produce
+	if e.opts.AutoExtendToMatchAll && len(norm) == 1 && norm[0].start == 0 && norm[0].count == 0 {
+		norm = []subExpression{{matchAll: true}}
+	}
+	return Expression{intervals: norm, opts: e.opts, isSorted: true}
 }
 
-// Convert Expression back to textual format.
-//
-// Consider the following situation
-//
-//   // Assume Input is valid for brevity
-//   Expr, _ := ParseExpression(Input)
-//   Norm    := Expr.Normalize()
-//
-// Now, the result of Expr.String() should resemble Input. However, if Expr !=
-// Norm, then Norm.String() likely differs greatly from Input. That is, a
-// normalized Expression is unlikely to serialize back to the original input
-// string (unless the input was written in normalized form to begin with).
-func (e Expression) String() string {
-	var ivs []string
+// Compact returns a new Expression without the subexpressions that are
+// entirely contained within another one, such as "3-5" in "1-10,3-5"; of
+// duplicate subexpressions only the first is kept. Unlike Normalize(),
+// overlapping and adjacent subexpressions are not merged, and the remaining
+// subexpressions retain their order. Negated subexpressions are only compared
+// with each other. The result matches exactly the same values as e.
+func (e Expression) Compact() Expression {
+	keep := make([]bool, len(e.intervals))
+	for _, negate := range []bool{false, true} {
+		var idx []int
+		for i, itv := range e.intervals {
+			if itv.negate == negate {
+				idx = append(idx, i)
+			}
+		}
+		// order by start, and the subexpressions extending furthest first
+		sort.SliceStable(idx, func(a, b int) bool {
+			x, y := e.intervals[idx[a]], e.intervals[idx[b]]
+			if x.matchAll != y.matchAll {
+				return x.matchAll
+			}
+			if x.start != y.start {
+				return x.start < y.start
+			}
+			if (x.count == 0) != (y.count == 0) {
+				return x.count == 0
+			}
+			return x.count > y.count
+		})
+		unbounded, seen, maxEnd := false, false, 0
+		for _, i := range idx {
+			itv := e.intervals[i]
+			switch {
+			case unbounded:
+			case itv.matchAll || itv.count == 0:
+				keep[i], unbounded = true, true
+			case seen && itv.start+itv.count-1 <= maxEnd:
+			default:
+				keep[i], seen, maxEnd = true, true, itv.start+itv.count-1
+			}
+		}
+	}
+	res := Expression{opts: e.opts, negated: e.negated}
+	for i, itv := range e.intervals {
+		if keep[i] {
+			res.intervals = append(res.intervals, itv)
+		}
+	}
+	return res
+}
+
+// Validate checks the internal consistency of the Expression: the count of
+// each subexpression must not be negative, a range must not extend beyond
+// math.MaxInt, and "*" must not carry a start or count. Expressions returned
+// by the parser and the methods of this package always pass; Validate is
+// meant as a sanity check for Expressions constructed by other means.
+func (e Expression) Validate() error {
+	negated := false
+	for i, itv := range e.intervals {
+		negated = negated || itv.negate
+		switch {
+		case itv.matchAll && (itv.start != 0 || itv.count != 0):
+			return fmt.Errorf("subexpression %d: wildcard with start %d and count %d", i, itv.start, itv.count)
+		case itv.count < 0:
+			return fmt.Errorf("subexpression %d: negative count %d", i, itv.count)
+		case itv.count > 1 && itv.start > math.MaxInt-(itv.count-1):
+			return fmt.Errorf("subexpression %d: range from %d with count %d overflows", i, itv.start, itv.count)
+		}
+	}
+	if negated != e.negated {
+		return fmt.Errorf("negated subexpressions are not marked on the Expression")
+	}
+	return nil
+}
+
+// IsConnected determines whether the integers matched by the Expression form a
+// single contiguous block, i.e whether the normalized Expression consists of
+// exactly one subexpression. For example '1-5', '3-' and '*' are connected,
+// while '1-3,5-7' is not. An Expression matching nothing is not connected.
+func (e Expression) IsConnected() bool {
+	return len(e.Normalize().intervals) == 1
+}
+
+// CountSubExpressionsOfType returns the number of subexpressions of each kind
+// in the Expression: single values ("1"), bounded ranges ("3-5"), half-open
+// ranges ("7-") and match-alls ("*"). The Expression is inspected as is; call
+// Normalize() first if you want the counts of the minimal representation.
+// Negated subexpressions are not counted.
+func (e Expression) CountSubExpressionsOfType() (singletons, ranges, halfOpen, matchAll int) {
 	for _, itv := range e.intervals {
-		ivs = append(ivs, itv.String())
+		switch {
+		case itv.negate:
+			continue
+		case itv.matchAll:
+			matchAll++
+		case itv.count == 0:
+			halfOpen++
+		case itv.count == 1:
+			singletons++
+		default:
+			ranges++
+		}
 	}
-	return strings.Join(ivs, e.opts.Delimiter)
+	return
 }
 
-// ParseExpression calls ParseExpressionWithOptions() with default options (see DefaultParseOptions())
-func ParseExpression(input string) (Expression, error) {
-	return ParseExpressionWithOptions(input, DefaultParseOptions())
+// Size returns the number of subexpressions in the Expression, and whether
+// all of them are bounded, i.e whether the Expression matches only a finite
+// number of integers. Negated subexpressions are first resolved as in
+// Normalize(); otherwise the subexpressions are counted as is.
+func (e Expression) Size() (count int, bounded bool) {
+	intervals := e.positive().intervals
+	bounded = true
+	for _, itv := range intervals {
+		if itv.matchAll || itv.count == 0 {
+			bounded = false
+			break
+		}
+	}
+	return len(intervals), bounded
 }
 
-// ParseExpressionWithOptions attempts to extract intervals expressions from input.
-//
-// ---
+// Complexity returns a cost metric for evaluating Matches(): the number of
+// subexpressions other than "*" (which matches immediately), i.e the
+// worst-case number of interval comparisons. Normalize() minimizes the
+// complexity of an Expression without changing the values it matches:
 //
-// An intervals expression consists of sequence of individual subexpressions.
+//	if expr.Complexity() > 100 {
+//		expr = expr.Normalize()
+//	}
+func (e Expression) Complexity() int {
+	n := 0
+	for _, itv := range e.intervals {
+		if !itv.matchAll {
+			n++
+		}
+	}
+	return n
+}
+
+// HasWildcard determines whether the Expression contains a "*"
+// subexpression. Unlike MatchesAll(), this is a purely syntactic check.
+// Negated subexpressions are ignored.
+func (e Expression) HasWildcard() bool {
+	for _, itv := range e.intervals {
+		if itv.matchAll && !itv.negate {
+			return true
+		}
+	}
+	return false
+}
+
+// WildcardCount returns the number of "*" subexpressions in the Expression,
+// ignoring negated ones. Normalize() reduces any number of them to one.
+func (e Expression) WildcardCount() int {
+	_, _, _, matchAll := e.CountSubExpressionsOfType()
+	return matchAll
+}
+
+// OpenCount returns the number of half-open subexpressions (such as "7-") in
+// the Expression, ignoring negated ones. Normalize() reduces any number of
+// them to at most one.
+func (e Expression) OpenCount() int {
+	_, _, halfOpen, _ := e.CountSubExpressionsOfType()
+	return halfOpen
+}
+
+// BoundedPart returns a new Expression consisting only of the finite
+// subexpressions of the Expression, i.e those other than "*" and half-open
+// ones. For example, the bounded part of '1,3-5,7-' is '1,3-5'. The order of
+// the subexpressions is retained, as are the options. Negated subexpressions
+// are resolved first; see Normalize().
+func (e Expression) BoundedPart() Expression {
+	var bounded []subExpression
+	for _, itv := range e.positive().intervals {
+		if !itv.matchAll && itv.count != 0 {
+			bounded = append(bounded, itv)
+		}
+	}
+	return Expression{intervals: bounded, opts: e.opts}
+}
+
+// UnboundedPart is the counterpart of BoundedPart(): it returns a new
+// Expression consisting only of the "*" and half-open subexpressions of the
+// Expression. For example, the unbounded part of '1,3-5,7-' is '7-'. An
+// Expression matches only finitely many integers iff its unbounded part
+// matches nothing (see MatchesNone()).
+func (e Expression) UnboundedPart() Expression {
+	var unbounded []subExpression
+	for _, itv := range e.positive().intervals {
+		if itv.matchAll || itv.count == 0 {
+			unbounded = append(unbounded, itv)
+		}
+	}
+	return Expression{intervals: unbounded, opts: e.opts}
+}
+
+// LargestInterval returns the bounded subexpression of the Expression that
+// contains the most integers; "*" and half-open subexpressions are ignored.
+// If several are equally large, the one with the smallest start wins. The
+// second return value is false if the Expression has no bounded
+// subexpressions. Note that the subexpressions are inspected as is; call
+// Normalize() first to consider overlapping subexpressions as one.
+func (e Expression) LargestInterval() (Interval, bool) {
+	var largest Interval
+	found := false
+	for _, itv := range e.positive().intervals {
+		if itv.matchAll || itv.count == 0 {
+			continue
+		}
+		if !found || itv.count > largest.Count() || (itv.count == largest.Count() && itv.start < largest.Start) {
+			largest = Interval{Start: itv.start, End: itv.start + itv.count - 1}
+			found = true
+		}
+	}
+	return largest, found
+}
+
+// SmallestInterval returns the bounded subexpression of the Expression that
+// contains the fewest integers; a single value is the smallest possible.
+// Otherwise it behaves like LargestInterval(): "*" and half-open
+// subexpressions are ignored, ties are resolved in favor of the smallest
+// start, and the second return value is false if there are no bounded
+// subexpressions.
+func (e Expression) SmallestInterval() (Interval, bool) {
+	var smallest Interval
+	found := false
+	for _, itv := range e.positive().intervals {
+		if itv.matchAll || itv.count == 0 {
+			continue
+		}
+		if !found || itv.count < smallest.Count() || (itv.count == smallest.Count() && itv.start < smallest.Start) {
+			smallest = Interval{Start: itv.start, End: itv.start + itv.count - 1}
+			found = true
+		}
+	}
+	return smallest, found
+}
+
+// DistanceTo returns the distance from val to the nearest integer matched by
+// the Expression, i.e 0 if Matches(val) is true. Distances too large to be
+// represented as int are reported as math.MaxInt. If the Expression matches
+// nothing, the result is -1.
+func (e Expression) DistanceTo(val int) int {
+	best := uint(math.MaxInt)
+	found := false
+	for _, itv := range e.positive().intervals {
+		found = true
+		var d uint
+		switch {
+		case itv.matches(val):
+			return 0
+		case val < itv.start:
+			d = uint(itv.start) - uint(val)
+		default:
+			d = uint(val) - uint(itv.start+itv.count-1)
+		}
+		if d < best {
+			best = d
+		}
+	}
+	if !found {
+		return -1
+	}
+	return int(best)
+}
+
+// IndexOf returns the 0-based position of val in the ascending enumeration of
+// the integers matched by the Expression, starting from lo; that is, the
+// number of matched integers in [lo, val-1]. The position saturates at
+// math.MaxInt. The second return value is false (and the position 0) if val
+// is not matched, or is less than lo.
+func (e Expression) IndexOf(val, lo int) (int, bool) {
+	if val < lo || !e.Matches(val) {
+		return 0, false
+	}
+	if val == lo {
+		return 0, true
+	}
+	n := e.Normalize().countInRange(lo, val-1)
+	if n > math.MaxInt {
+		return math.MaxInt, true
+	}
+	return int(n), true
+}
+
+// countInRange returns the number of integers in [lo, hi] matched by e,
+// saturating at math.MaxUint. The Expression must be normalized.
+func (e Expression) countInRange(lo, hi int) uint {
+	var n uint
+	for _, itv := range e.intervals {
+		a, b := itv.clip(lo, hi)
+		if a > b {
+			continue
+		}
+		c := uint(b) - uint(a)
+		if c == math.MaxUint || n > math.MaxUint-c-1 {
+			return math.MaxUint
+		}
+		n += c + 1
+	}
+	return n
+}
+
+// Random returns an integer chosen uniformly at random among the integers in
+// the closed range [lo, hi] matched by the Expression, using the global
+// random source of math/rand. An error is returned if there are none.
+func (e Expression) Random(lo, hi int) (int, error) {
+	norm := e.Normalize()
+	total := norm.countInRange(lo, hi)
+	if total == 0 {
+		return 0, fmt.Errorf("no matching integers in range [%d, %d]", lo, hi)
+	}
+	return norm.nthInRange(uint(randomBelow(uint64(total))), lo, hi), nil
+}
+
+// nthInRange returns the k-th (counting from 0) of the integers in [lo, hi]
+// matched by e, in ascending order. The Expression must be normalized, and
+// match more than k integers in the range.
+func (e Expression) nthInRange(k uint, lo, hi int) int {
+	for _, itv := range e.intervals {
+		a, b := itv.clip(lo, hi)
+		if a > b {
+			continue
+		}
+		if c := uint(b) - uint(a); k > c {
+			k -= c + 1
+			continue
+		}
+		return int(uint(a) + k)
+	}
+	panic("nthInRange: k out of range")
+}
+
+// randomBelow returns a uniformly distributed random integer in [0, n), n > 0.
+func randomBelow(n uint64) uint64 {
+	if n&(n-1) == 0 {
+		return rand.Uint64() & (n - 1)
+	}
+	limit := math.MaxUint64 - math.MaxUint64%n
+	for {
+		if v := rand.Uint64(); v < limit {
+			return v % n
+		}
+	}
+}
+
+// Equal determines whether e and other match exactly the same integers. Only
+// the content of the Expressions is compared: the order, overlap and notation
+// of the subexpressions do not matter, and neither do the ParseOptions; e.g
+// '3-5,1,4' and '1;3-5' (with ';' delimiter) are equal. See also
+// EqualWithOpts().
+func (e Expression) Equal(other Expression) bool {
+	a, b := e.Normalize().intervals, other.Normalize().intervals
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].matchAll != b[i].matchAll || a[i].start != b[i].start || a[i].count != b[i].count {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualWithOpts is a strict version of Equal(), additionally requiring that e
+// and other have equal ParseOptions, so that e.g their textual forms are
+// parsed alike. Function fields of the options are equal if they refer to
+// the same function.
+func (e Expression) EqualWithOpts(other Expression) bool {
+	return e.Equal(other) && parseOptionsEqual(e.opts, other.opts)
+}
+
+// parseOptionsEqual compares ParseOptions field by field; see EqualWithOpts().
+func parseOptionsEqual(a, b ParseOptions) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		fa, fb := va.Field(i), vb.Field(i)
+		if fa.Kind() == reflect.Func {
+			if fa.Pointer() != fb.Pointer() {
+				return false
+			}
+		} else if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Key returns a canonical string form of the Expression, suitable for use as a
+// map key (Expression itself is not comparable). Expressions matching the same
+// integers have equal keys, regardless of the order, overlap, delimiter or
+// notation (see ParseOptions.IntBase) of their subexpressions; e.g '3-5,1,4'
+// and '1;3-5' (with ';' delimiter) both have the key "1,3-5".
+func (e Expression) Key() string {
+	var ivs []string
+	for _, itv := range e.Normalize().intervals {
+		// always decimal, regardless of the notation of the input
+		itv.notation = valueNotation{}
+		ivs = append(ivs, itv.String())
+	}
+	return strings.Join(ivs, ",")
+}
+
+// ForEach calls fn for each integer in the closed range [lo, hi] that is
+// contained within the intervals expression, in ascending order. The values
+// are computed from the interval bounds; no intermediate slice of values is
+// constructed.
+func (e Expression) ForEach(lo, hi int, fn func(int)) {
+	e.ForEachWhile(lo, hi, func(val int) bool {
+		fn(val)
+		return true
+	})
+}
+
+// ForEachWhile is like ForEach, except that the iteration stops as soon as fn
+// returns false.
+func (e Expression) ForEachWhile(lo, hi int, fn func(int) bool) {
+	if lo > hi {
+		return
+	}
+	norm := e.Normalize()
+	if norm.MatchesAll() {
+		forEachInRange(lo, hi, fn)
+		return
+	}
+	// normalized intervals are disjoint and in ascending order
+	for _, itv := range norm.intervals {
+		if itv.start > hi {
+			return
+		}
+		a, b := itv.start, hi
+		if itv.count != 0 && itv.start+itv.count-1 < hi {
+			b = itv.start + itv.count - 1
+		}
+		if a < lo {
+			a = lo
+		}
+		if a > b {
+			continue
+		}
+		if !forEachInRange(a, b, fn) {
+			return
+		}
+	}
+}
+
+// ExpandTo appends the integers in the closed range [lo, hi] that are
+// contained within the intervals expression to dst, in ascending order, and
+// returns the extended slice (like the built-in append). Beware of large
+// ranges: every matched value is stored.
+func (e Expression) ExpandTo(dst []int, lo, hi int) []int {
+	e.ForEach(lo, hi, func(val int) {
+		dst = append(dst, val)
+	})
+	return dst
+}
+
+// Reduce folds fn over the integers in the closed range [lo, hi] that are
+// contained within the intervals expression, in ascending order: the
+// accumulator starts as initial, and is replaced by fn(acc, val) for each
+// value. The final accumulator is returned. See ReduceInts() for a type-safe
+// version.
+func (e Expression) Reduce(fn func(acc interface{}, val int) interface{}, initial interface{}, lo, hi int) interface{} {
+	return ReduceInts(e, fn, initial, lo, hi)
+}
+
+// ReduceInts is the generic version of e.Reduce(), e.g for summing the
+// matched integers:
+//
+//	sum := ReduceInts(e, func(acc, val int) int { return acc + val }, 0, 1, 100)
+func ReduceInts[T any](e Expression, fn func(acc T, val int) T, initial T, lo, hi int) T {
+	acc := initial
+	e.ForEach(lo, hi, func(val int) {
+		acc = fn(acc, val)
+	})
+	return acc
+}
+
+// forEachInRange calls fn for each integer in [lo, hi] (lo <= hi) until fn
+// returns false, in which case forEachInRange also returns false. Written
+// to avoid overflow when hi == math.MaxInt.
+func forEachInRange(lo, hi int, fn func(int) bool) bool {
+	for v := lo; ; v++ {
+		if !fn(v) {
+			return false
+		}
+		if v == hi {
+			return true
+		}
+	}
+}
+
+// Adjacent determines whether the Expression and other abut each other without
+// overlapping, i.e whether they have no values in common but together match a
+// single contiguous block of integers. For example '1-5' and '6-10' are
+// adjacent, while '1-5' and '5-10' (overlap) or '1-5' and '7-10' (gap) are
+// not. Expressions matching nothing are never adjacent to anything.
+func (e Expression) Adjacent(other Expression) bool {
+	e, other = e.positive(), other.positive()
+	if e.MatchesNone() || other.MatchesNone() {
+		return false
+	}
+	for _, a := range e.intervals {
+		for _, b := range other.intervals {
+			if a.overlaps(b) {
+				return false
+			}
+		}
+	}
+	combined := make([]subExpression, 0, len(e.intervals)+len(other.intervals))
+	combined = append(combined, e.intervals...)
+	combined = append(combined, other.intervals...)
+	return Expression{intervals: combined}.IsConnected()
+}
+
+// ZipWith combines the Expression with other by applying fn to their match
+// results for each integer in the closed range [low, high]; the resulting
+// Expression matches those integers for which fn returned true. For example,
+//   a.ZipWith(b, func(x, y bool) bool { return x && y }, 0, 100)
+// matches the integers in [0, 100] matched by both a and b. The result is
+// normalized and never matches anything outside [low, high], even if fn
+// returns true for (false, false). It retains the options of the receiver.
+//
+// fn is not called for every integer individually, but once for each range of
+// integers in which neither of the match results change.
+func (e Expression) ZipWith(other Expression, fn func(bool, bool) bool, low, high int) Expression {
+	res := Expression{opts: e.opts}
+	if low > high {
+		return res
+	}
+
+	// collect the points in [low, high] where either match result may change
+	points := []int{low}
+	for _, itvs := range [][]subExpression{e.intervals, other.intervals} {
+		for _, itv := range itvs {
+			if itv.matchAll {
+				continue
+			}
+			if itv.start > low && itv.start <= high {
+				points = append(points, itv.start)
+			}
+			if itv.count != 0 {
+				end := itv.start + itv.count - 1
+				if end >= low && end < high {
+					points = append(points, end+1)
+				}
+			}
+		}
+	}
+	sort.Ints(points)
+
+	for i, a := range points {
+		if i > 0 && a == points[i-1] {
+			continue
+		}
+		b := high
+		for j := i + 1; j < len(points); j++ {
+			if points[j] != a {
+				b = points[j] - 1
+				break
+			}
+		}
+		if !fn(e.Matches(a), other.Matches(a)) {
+			continue
+		}
+		if n := len(res.intervals); n > 0 && res.intervals[n-1].start+res.intervals[n-1].count == a {
+			res.intervals[n-1].count += b - a + 1
+		} else {
+			res.intervals = append(res.intervals, subExpression{start: a, count: b - a + 1})
+		}
+	}
+	return res
+}
+
+// Union returns a new normalized Expression matching every integer matched by
+// either a or b. The result retains the options of a.
+func Union(a, b Expression) Expression {
+	a, b = a.positive(), b.positive()
+	combined := make([]subExpression, 0, len(a.intervals)+len(b.intervals))
+	combined = append(combined, a.intervals...)
+	combined = append(combined, b.intervals...)
+	return Expression{intervals: combined, opts: a.opts}.Normalize()
+}
+
+// Merge is the method form of Union(e, other), allowing for chained calls
+// such as a.Merge(b).Merge(c). Despite the name, the receiver is not
+// modified; like all Expression methods, Merge returns a new Expression.
+func (e Expression) Merge(other Expression) Expression {
+	return Union(e, other)
+}
+
+// Subtract returns a new normalized Expression matching the integers matched
+// by a but not by b (i.e the set difference a \ b). Only non-negative
+// integers are considered, as negative ones can not be written in an
+// expression; e.g "*" minus "1-5" is "0,6-". The result retains the options
+// of a.
+func Subtract(a, b Expression) Expression {
+	return combine(a, b, func(x, y bool) bool { return x && !y })
+}
+
+// Subtract is the method form of Subtract(e, other), allowing for chained
+// calls such as a.Subtract(b).Subtract(c).
+func (e Expression) Subtract(other Expression) Expression {
+	return Subtract(e, other)
+}
+
+// Difference returns a new normalized Expression matching the integers
+// matched by base but by none of the exclusions, i.e base \ (exclusions[0] ∪
+// exclusions[1] ∪ ...). Given no exclusions, base is returned unchanged (and
+// thus not necessarily normalized). The result retains the options of base.
+func Difference(base Expression, exclusions ...Expression) Expression {
+	if len(exclusions) == 0 {
+		return base
+	}
+	excluded := exclusions[0]
+	for _, ex := range exclusions[1:] {
+		excluded = Union(excluded, ex)
+	}
+	return Subtract(base, excluded)
+}
+
+// Intersect returns a new normalized Expression matching the integers matched
+// by both a and b. The result retains the options of a.
+func Intersect(a, b Expression) Expression {
+	return combine(a, b, func(x, y bool) bool { return x && y })
+}
+
+// Intersect is the method form of Intersect(e, other), allowing for chained
+// calls such as a.Intersect(b).Intersect(c).
+func (e Expression) Intersect(other Expression) Expression {
+	return Intersect(e, other)
+}
+
+// SymmetricDiff returns a new normalized Expression matching the integers
+// matched by exactly one of a and b, i.e (a ∪ b) \ (a ∩ b). The result
+// retains the options of a.
+func SymmetricDiff(a, b Expression) Expression {
+	return combine(a, b, func(x, y bool) bool { return x != y })
+}
+
+// SymmetricDiff is the method form of SymmetricDiff(e, other).
+func (e Expression) SymmetricDiff(other Expression) Expression {
+	return SymmetricDiff(e, other)
+}
+
+// SubsetOf determines whether every integer matched by a is also matched by b
+// (i.e a ⊆ b). An Expression matching nothing is a subset of any Expression.
+// As with Subtract(), only non-negative integers are considered, so e.g "*"
+// is a subset of "0-".
+func SubsetOf(a, b Expression) bool {
+	return Subtract(a, b).MatchesNone()
+}
+
+// SubsetOf is the method form of SubsetOf(e, other).
+func (e Expression) SubsetOf(other Expression) bool {
+	return SubsetOf(e, other)
+}
+
+// Complement returns a new normalized Expression matching the integers in the
+// closed range [lo, hi] that are not matched by e. If lo > hi, the range is
+// empty, and so is the result. The result retains the options of e.
+func Complement(e Expression, lo, hi int) Expression {
+	return e.ZipWith(Expression{}, func(x, _ bool) bool { return !x }, lo, hi)
+}
+
+// Invert is the method form of Complement(e, lo, hi), allowing for chained
+// calls such as e.Normalize().Invert(1, 100).
+func (e Expression) Invert(lo, hi int) Expression {
+	return Complement(e, lo, hi)
+}
+
+// Translate returns a new Expression in which every subexpression is shifted
+// by delta: positive delta moves the values up, negative delta down. Since
+// negative values cannot be written in an expression, the shifted values are
+// clipped at zero; subexpressions that fall entirely below zero (or above
+// math.MaxInt) are dropped. "*" is retained as is. For example "5,1-3"
+// shifted by 2 becomes "7,3-5", while "1-10,^5" (with negation) becomes
+// "3-6,8-12", as the exclusion is resolved before shifting.
+func (e Expression) Translate(delta int) Expression {
+	res := Expression{opts: e.opts}
+	for _, itv := range e.positive().intervals {
+		if itv.matchAll {
+			res.intervals = append(res.intervals, itv)
+			continue
+		}
+		if delta > 0 && itv.start > math.MaxInt-delta {
+			continue
+		}
+		start := math.MinInt
+		if delta >= 0 || itv.start >= math.MinInt-delta {
+			start = itv.start + delta
+		}
+		if start < 0 {
+			start = 0
+		}
+		if itv.count == 0 {
+			res.intervals = append(res.intervals, subExpression{start: start, notation: itv.notation})
+			continue
+		}
+		end := itv.start + itv.count - 1
+		if delta < 0 && end < math.MinInt-delta {
+			continue
+		}
+		if delta > 0 && end > math.MaxInt-delta {
+			end = math.MaxInt
+		} else {
+			end += delta
+		}
+		if end < 0 {
+			continue
+		}
+		n := len(res.intervals)
+		res.intervals = appendRange(res.intervals, start, end)
+		for i := n; i < len(res.intervals); i++ {
+			res.intervals[i].notation = itv.notation
+		}
+	}
+	return res
+}
+
+// Scale returns a new Expression in which the endpoints of every
+// subexpression are multiplied by factor; for example "2-3,5-" scaled by 10
+// becomes "20-30,50-". Note that a range matches every integer between its
+// scaled endpoints, not just the multiples of factor. "*" is retained as is.
+// An error is returned if factor is not positive, or if a scaled endpoint
+// does not fit into an int. Exclusions such as "^5" are resolved before
+// scaling, and the endpoints keep their notation, e.g "0x2" scaled by 8 is
+// written "0x10".
+func (e Expression) Scale(factor int) (Expression, error) {
+	if factor <= 0 {
+		return Expression{}, fmt.Errorf("scale factor must be positive, got %d", factor)
+	}
+	scale := func(v int) (int, error) {
+		if v > math.MaxInt/factor || v < math.MinInt/factor {
+			return 0, fmt.Errorf("scaling %d by %d overflows", v, factor)
+		}
+		return v * factor, nil
+	}
+	res := Expression{opts: e.opts}
+	for _, itv := range e.positive().intervals {
+		if itv.matchAll {
+			res.intervals = append(res.intervals, itv)
+			continue
+		}
+		start, err := scale(itv.start)
+		if err != nil {
+			return Expression{}, err
+		}
+		if itv.count == 0 {
+			res.intervals = append(res.intervals, subExpression{start: start, notation: itv.notation})
+			continue
+		}
+		end, err := scale(itv.start + itv.count - 1)
+		if err != nil {
+			return Expression{}, err
+		}
+		n := len(res.intervals)
+		res.intervals = appendRange(res.intervals, start, end)
+		for i := n; i < len(res.intervals); i++ {
+			res.intervals[i].notation = itv.notation
+		}
+	}
+	return res, nil
+}
+
+// Truncate returns a new Expression matching only those values of e that are
+// at most maxVal, converting an unbounded Expression into a finite one: for
+// example "1-3,7-" truncated at 10 becomes "1-3,7-10". Subexpressions
+// starting above maxVal are dropped, and "*" becomes "0-maxVal" (as
+// expressions cannot contain negative values). The remaining subexpressions
+// keep their original order, unless there are exclusions to subtract first:
+// "7-,1-3,^2" truncated at 10 becomes "1,3,7-10".
+func (e Expression) Truncate(maxVal int) Expression {
+	res := Expression{opts: e.opts}
+	for _, itv := range e.positive().intervals {
+		if itv.matchAll {
+			if maxVal >= 0 {
+				res.intervals = appendRange(res.intervals, 0, maxVal)
+			}
+			continue
+		}
+		if itv.start > maxVal {
+			continue
+		}
+		end := maxVal
+		if itv.count != 0 && itv.start+itv.count-1 < maxVal {
+			end = itv.start + itv.count - 1
+		}
+		n := len(res.intervals)
+		res.intervals = appendRange(res.intervals, itv.start, end)
+		for i := n; i < len(res.intervals); i++ {
+			res.intervals[i].notation = itv.notation
+		}
+	}
+	return res
+}
+
+// combine constructs a normalized Expression matching the non-negative
+// integers x for which fn(a.Matches(x), b.Matches(x)) is true. This is the
+// basis of the set operations; fn(false, false) must be false, as otherwise
+// the result would need to match values outside any subexpression. Negative
+// values can not be written in an expression, so they are left out, except
+// that the result is "*" if it also matches them; e.g "*" minus "1-5" is
+// "0,6-". The result retains the options of a.
+func combine(a, b Expression, fn func(bool, bool) bool) Expression {
+	res := Expression{opts: a.opts, isSorted: true}
+
+	// collect the points where either match result may change
+	points := []int{0}
+	for _, itvs := range [][]subExpression{a.intervals, b.intervals} {
+		for _, itv := range itvs {
+			if itv.matchAll {
+				continue
+			}
+			if itv.start > 0 {
+				points = append(points, itv.start)
+			}
+			if itv.count != 0 && itv.start+itv.count-1 >= 0 && itv.start+itv.count-1 < math.MaxInt {
+				points = append(points, itv.start+itv.count)
+			}
+		}
+	}
+	sort.Ints(points)
+
+	runStart, inRun := 0, false
+	for i, p := range points {
+		if i > 0 && p == points[i-1] {
+			continue
+		}
+		match := fn(a.Matches(p), b.Matches(p))
+		if match && !inRun {
+			runStart, inRun = p, true
+		} else if !match && inRun {
+			res.intervals = appendRange(res.intervals, runStart, p-1)
+			inRun = false
+		}
+	}
+	// only "*" and half-open subexpressions match beyond the last point
+	if inRun {
+		if runStart == 0 && fn(a.Matches(math.MinInt), b.Matches(math.MinInt)) {
+			res.intervals = []subExpression{{matchAll: true}}
+		} else {
+			res.intervals = append(res.intervals, subExpression{start: runStart, count: 0})
+		}
+	}
+	return res
+}
+
+// appendRange appends subexpressions matching exactly the values [lo, hi]
+// (lo <= hi) to intervals. Ranges too long for a single subexpression count
+// (only possible with negative values) are split in parts.
+func appendRange(intervals []subExpression, lo, hi int) []subExpression {
+	for uint(hi)-uint(lo) >= math.MaxInt {
+		intervals = append(intervals, subExpression{start: lo, count: math.MaxInt})
+		lo += math.MaxInt
+	}
+	return append(intervals, subExpression{start: lo, count: hi - lo + 1})
+}
+
+// maxRegexpValues limits the number of integers ToRegexp() is willing to
+// enumerate into a regular expression.
+const maxRegexpValues = 10000
+
+// ToRegexp constructs a regular expression matching exactly the string
+// representations (in the given base, 2 to 36, lowercase and without leading
+// zeros or prefixes) of the integers matched by the Expression. For example,
+// '1,3-5' gives a regexp equivalent to `^(?:1|3|4|5)$` for base 10.
+//
+// The values are enumerated one by one, so this is only practical for small
+// finite expressions; an error is returned for Expressions containing "*" or
+// half-open subexpressions, or matching more than 10000 integers.
+func (e Expression) ToRegexp(base int) (*regexp.Regexp, error) {
+	if base < 2 || base > 36 {
+		return nil, fmt.Errorf("invalid base: %d", base)
+	}
+	norm := e.Normalize()
+	total := 0
+	for _, itv := range norm.intervals {
+		if itv.matchAll || itv.count == 0 {
+			return nil, fmt.Errorf("cannot construct regexp for unbounded subexpression %q", itv)
+		}
+		total += itv.count
+		if total > maxRegexpValues {
+			return nil, fmt.Errorf("expression matches too many values for a regexp (limit is %d)", maxRegexpValues)
+		}
+	}
+	alternatives := make([]string, 0, total)
+	for _, itv := range norm.intervals {
+		forEachInRange(itv.start, itv.start+itv.count-1, func(val int) bool {
+			alternatives = append(alternatives, strconv.FormatInt(int64(val), base))
+			return true
+		})
+	}
+	return regexp.Compile(`^(?:` + strings.Join(alternatives, "|") + `)$`)
+}
+
+// Convert Expression back to textual format.
+//
+// Consider the following situation
+//
+//   // Assume Input is valid for brevity
+//   Expr, _ := ParseExpression(Input)
+//   Norm    := Expr.Normalize()
+//
+// Now, the result of Expr.String() should resemble Input, except for
+// superfluous whitespace and empty subexpressions, which are dropped, and
+// ranges with equal endpoints such as '5-5', which are written as a single
+// value '5'. However, if Expr != Norm, then Norm.String() likely differs
+// greatly from Input. That is, a normalized Expression is unlikely to
+// serialize back to the original input string (unless the input was written
+// in normalized form to begin with).
+//
+// In either case the textual form round-trips: parsing Expr.String() with the
+// same ParseOptions yields an Expression with exactly the same subexpressions
+// (in the same order) as Expr.
+//
+// The textual form of a normalized Expression is deterministic: normalized
+// Expressions matching the same integers (with the same ParseOptions, and
+// values written in the same notation) have identical textual forms,
+// regardless of the order or overlap of the original subexpressions.
+//
+// If the Expression has no delimiter (e.g it was constructed without
+// ParseOptions), the subexpressions are separated by ",".
+func (e Expression) String() string {
+	var ivs []string
+	for _, itv := range e.intervals {
+		if e.opts.ZeroBased && !itv.matchAll {
+			itv.start++
+		}
+		ivs = append(ivs, itv.String())
+	}
+	delimiter := e.opts.Delimiter
+	if delimiter == "" {
+		// e.g the zero value ParseOptions; the subexpressions must remain
+		// distinguishable
+		delimiter = DefaultParseOptions().Delimiter
+	}
+	return strings.Join(ivs, delimiter)
+}
+
+// MarshalJSON implements json.Marshaler; the Expression is encoded as a JSON
+// string containing its textual form (see String()).
+func (e Expression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.String())
+}
+
+// ToJSON returns the JSON encoding of the Expression, as produced by
+// MarshalJSON(), for callers that do not use json.Marshal() directly.
+func (e Expression) ToJSON() ([]byte, error) {
+	return e.MarshalJSON()
+}
+
+// ToJSONString is like ToJSON(), but returns the encoding as a string.
+func (e Expression) ToJSONString() (string, error) {
+	b, err := e.ToJSON()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// MarshalText implements encoding.TextMarshaler, which is used e.g by TOML
+// and YAML encoders; the text is the textual form of the Expression (see
+// String()).
+func (e Expression) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// MarshalTOML encodes the Expression as a TOML basic string containing its
+// textual form, as expected by the Marshaler interface of
+// github.com/BurntSushi/toml. TOML encoders relying on
+// encoding.TextMarshaler instead (such as github.com/pelletier/go-toml/v2)
+// use MarshalText().
+func (e Expression) MarshalTOML() ([]byte, error) {
+	// a JSON string is also a valid TOML basic string
+	return e.MarshalJSON()
+}
+
+// WriteTo implements io.WriterTo, writing the textual form of the Expression
+// (see String()) to w.
+func (e Expression) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, e.String())
+	return int64(n), err
+}
+
+// ReadFrom implements io.ReaderFrom, reading r until EOF and parsing the
+// contents into e with the ParseOptions of e, or with DefaultParseOptions()
+// if e has none (as is the case for the zero value). On error, e is left
+// unchanged. The number of bytes read is returned in either case.
+func (e *Expression) ReadFrom(r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	parsed, err := ParseExpressionWithOptions(string(data), e.reparseOptions())
+	if err != nil {
+		return int64(len(data)), err
+	}
+	*e = parsed
+	return int64(len(data)), nil
+}
+
+// reparseOptions returns the ParseOptions of e for parsing a new value into
+// e, or DefaultParseOptions() if e has none (as is the case for the zero
+// value).
+func (e Expression) reparseOptions() ParseOptions {
+	if e.opts.Delimiter == "" && e.opts.DelimiterRegexp == nil {
+		return DefaultParseOptions()
+	}
+	return e.opts
+}
+
+// Scan implements fmt.Scanner, so that an Expression can be read with
+// fmt.Sscan() and friends, completing the round-trip from fmt.Sprint(). The
+// next whitespace-delimited token of the input is parsed with the
+// ParseOptions of e, or with DefaultParseOptions() if e has none (as is the
+// case for the zero value). Only the verbs %v and %s are supported.
+func (e *Expression) Scan(state fmt.ScanState, verb rune) error {
+	if verb != 'v' && verb != 's' {
+		return fmt.Errorf("unsupported verb %%%c for Expression", verb)
+	}
+	token, err := state.Token(true, nil)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseExpressionWithOptions(string(token), e.reparseOptions())
+	if err != nil {
+		return err
+	}
+	*e = parsed
+	return nil
+}
+
+// ParseExpression calls ParseExpressionWithOptions() with default options (see DefaultParseOptions())
+func ParseExpression(input string) (Expression, error) {
+	return ParseExpressionWithOptions(input, DefaultParseOptions())
+}
+
+// ParseExpressionWithOptions attempts to extract intervals expressions from input.
+//
+// ---
+//
+// An intervals expression consists of sequence of individual subexpressions.
 //
 // A subexpression describes a continuous range of integral values (i.e an
 // interval).  A single subexpression string contains one of the following:
@@ -248,7 +2038,9 @@ func ParseExpression(input string) (Expression, error) {
 // - an integer and a dash, for example "7-": denotes all integers from 7 to
 // infinity (i.e 7,8,9,...)
 //
-// Currently the parser supports only positive integer values in subexpressions.
+// Currently the parser supports only non-negative integer values (i.e
+// integers >= 0) in subexpressions; zero is a valid value, as in "0", "0-5"
+// and "0-".
 //
 // Additionally, the parser recognizes a subexpressions equal to "*" and
 // interprets them as "match everything". Note that such subexpression will
@@ -295,62 +2087,474 @@ func ParseExpression(input string) (Expression, error) {
 // NOTE: The resulting Expression is not guaranteed to be normalized, unless
 // you set opts.PostProcessNormalize=true, or manually call .Normalize() on the result.
 func ParseExpressionWithOptions(input string, opts ParseOptions) (Expression, error) {
-	if opts.Delimiter == "" {
+	if opts.RecoverFromPanic {
+		return parseExpressionRecovering(input, opts)
+	}
+	return parseExpression(input, opts)
+}
+
+// hookPanic carries a panic raised in a user-supplied hook; see guardHook().
+type hookPanic struct {
+	value interface{}
+}
+
+// guardHook calls fn, which is expected to call a user-supplied hook. If
+// opts.RecoverFromPanic is set, a panic in fn is re-raised as a hookPanic, so
+// that parseExpressionRecovering() can tell it apart from other panics.
+func (opts ParseOptions) guardHook(fn func()) {
+	if opts.RecoverFromPanic {
+		defer func() {
+			if r := recover(); r != nil {
+				panic(hookPanic{r})
+			}
+		}()
+	}
+	fn()
+}
+
+// parseExpressionRecovering calls parseExpression(), converting a panic in
+// one of the user-supplied hooks into an error. Other panics propagate.
+func parseExpressionRecovering(input string, opts ParseOptions) (e Expression, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			hp, ok := r.(hookPanic)
+			if !ok {
+				panic(r)
+			}
+			e, err = Expression{}, fmt.Errorf("panic while parsing %q: %v", input, hp.value)
+		}
+	}()
+	return parseExpression(input, opts)
+}
+
+// parseExpression implements ParseExpressionWithOptions().
+func parseExpression(input string, opts ParseOptions) (Expression, error) {
+	if opts.Delimiter == "" && opts.DelimiterRegexp == nil {
 		return Expression{}, fmt.Errorf("ParseOptions.Delimiter is empty")
 	}
-	r, err := regexp.Compile(`\s*` + regexp.QuoteMeta(opts.Delimiter) + `\s*`)
+	if v := opts.ExpressionVersion; v < 0 || v > CurrentExpressionVersion {
+		return Expression{}, fmt.Errorf("ParseOptions.ExpressionVersion %d is not supported (newest supported is %d); upgrade the library", v, CurrentExpressionVersion)
+	}
+	if b := opts.IntBase; b != 0 && b != IntBaseAuto && (b < 2 || b > 36) {
+		return Expression{}, fmt.Errorf("ParseOptions.IntBase is invalid: %d", b)
+	}
+	if opts.Locale != "" {
+		sep, err := localeGroupSeparator(opts.Locale)
+		if err != nil {
+			return Expression{}, err
+		}
+		if opts.DelimiterRegexp == nil && strings.Contains(opts.Delimiter, sep) {
+			return Expression{}, fmt.Errorf("digit grouping separator %q of ParseOptions.Locale %q conflicts with ParseOptions.Delimiter", sep, opts.Locale)
+		}
+	}
+	r := opts.DelimiterRegexp
+	if r != nil {
+		if err := checkDelimiterRegexp(r, opts); err != nil {
+			return Expression{}, err
+		}
+	} else {
+		var err error
+		if r, err = regexp.Compile(`\s*` + regexp.QuoteMeta(opts.Delimiter) + `\s*`); err != nil {
+			return Expression{}, fmt.Errorf("Invalid delimiter: %w", err)
+		}
+	}
+	intervals, err := parseSubExpressions(input, 0, r, opts)
+	if err != nil {
+		return Expression{}, err
+	}
+
+	e := Expression{intervals: intervals, opts: opts}
+	for _, itv := range intervals {
+		e.negated = e.negated || itv.negate
+	}
+
+	if e.MatchesNone() && !opts.AllowEmptyExpression {
+		return Expression{}, opts.errorf(ErrorKindEmptyExpression, input, 0, "current options prohibit empty expressions")
+	}
+	if len(intervals) == 0 && opts.FallbackExpression != nil {
+		return *opts.FallbackExpression, nil
+	}
+
+	if opts.AutoExtendToMatchAll && !opts.PostProcessNormalize && !e.MatchesAll() && e.Normalize().MatchesAll() {
+		e.intervals = []subExpression{{matchAll: true}}
+	}
+	if opts.StripRedundantWildcard {
+		e.intervals = stripRedundantWildcard(e.intervals)
+	}
+
+	var warnings []string
+	if opts.IntervalCountWarning > 0 && len(intervals) > opts.IntervalCountWarning {
+		warnings = append(warnings, fmt.Sprintf("expression contains %d subexpressions, more than the suggested maximum of %d",
+			len(intervals), opts.IntervalCountWarning))
+	}
+	warnings = append(warnings, redundancyWarnings(intervals)...)
+	if opts.WarnHandler != nil {
+		for _, w := range warnings {
+			opts.guardHook(func() { opts.WarnHandler(w) })
+		}
+	}
+
+	if opts.PostProcessNormalize {
+		e = e.Normalize()
+	}
+	if opts.SubExpressionSort != nil {
+		sort.SliceStable(e.intervals, func(a, b int) bool {
+			x, y := e.intervals[a].info(), e.intervals[b].info()
+			less := false
+			opts.guardHook(func() { less = opts.SubExpressionSort(x, y) })
+			return less
+		})
+		// the order may differ from that of Normalize()
+		e.isSorted = false
+	}
+	e.warnings = warnings
+	return e, nil
+}
+
+// stripRedundantWildcard drops the subexpressions made redundant by a "*" in
+// intervals (all but the first "*", and the other non-negated ones); see
+// ParseOptions.StripRedundantWildcard.
+func stripRedundantWildcard(intervals []subExpression) []subExpression {
+	wildcard := -1
+	for i, itv := range intervals {
+		if itv.matchAll && !itv.negate {
+			wildcard = i
+			break
+		}
+	}
+	if wildcard < 0 {
+		return intervals
+	}
+	var res []subExpression
+	for i, itv := range intervals {
+		if i == wildcard || itv.negate {
+			res = append(res, itv)
+		}
+	}
+	return res
+}
+
+// checkDelimiterRegexp verifies that the delimiter regexp r does not match
+// any part of typical subexpressions, which would then be split apart.
+func checkDelimiterRegexp(r *regexp.Regexp, opts ParseOptions) error {
+	tokens := []string{"0123456789", "1-2", "3-", "*"}
+	if opts.Locale != "" {
+		sep, _ := localeGroupSeparator(opts.Locale)
+		tokens = append(tokens, "1"+sep+"000")
+	}
+	if opts.AllowPrefixPlus {
+		tokens = append(tokens, "+1")
+	}
+	if opts.AllowNegation {
+		tokens = append(tokens, "^1")
+	}
+	for _, token := range tokens {
+		if r.MatchString(token) {
+			return fmt.Errorf("ParseOptions.DelimiterRegexp %q matches within subexpression %q", r, token)
+		}
+	}
+	return nil
+}
+
+// parseSubExpressions splits input on the delimiter regexp r, and parses each
+// of the parts into a subexpression. If opts.AllowNested is set, the contents
+// of parenthesized groups are parsed recursively. The offset is the position
+// of input in the complete input string, for error reporting.
+func parseSubExpressions(input string, offset int, r *regexp.Regexp, opts ParseOptions) ([]subExpression, error) {
+	parts, positions, err := splitSubExpressions(input, offset, r, opts)
 	if err != nil {
-		return Expression{}, fmt.Errorf("Invalid delimiter: %w", err)
+		return nil, err
 	}
-	intervalsRaw := r.Split(input, -1)
 	var intervals []subExpression
-	for _, intervalStr := range intervalsRaw {
+	for i, intervalStr := range parts {
+		pos := positions[i]
+		if trimmed := strings.TrimSpace(intervalStr); opts.AllowNested && strings.HasPrefix(trimmed, "(") {
+			if !strings.HasSuffix(trimmed, ")") {
+				return nil, opts.errorf(ErrorKindInvalidSyntax, intervalStr, pos, "invalid syntax: %q", intervalStr)
+			}
+			inner := pos + strings.Index(intervalStr, "(") + 1
+			group, err := parseSubExpressions(trimmed[1:len(trimmed)-1], inner, r, opts)
+			if err != nil {
+				return nil, err
+			}
+			intervals = append(intervals, group...)
+			continue
+		}
 		if intervalStr != "" {
-			interval, err := parseSubExpression(intervalStr)
+			token := intervalStr
+			negate := false
+			if trimmed := strings.TrimSpace(intervalStr); opts.AllowNegation && strings.HasPrefix(trimmed, "^") {
+				negate, intervalStr = true, trimmed[1:]
+			}
+			interval, err := parseSubExpression(intervalStr, pos, opts)
 			if err != nil {
-				return Expression{}, err
+				return nil, err
+			}
+			interval.negate = negate
+			if opts.ZeroBased && !interval.matchAll {
+				if interval.start == 0 {
+					return nil, opts.errorf(ErrorKindInvalidValue, token, pos, "value 0 is invalid in 1-based input: %q", intervalStr)
+				}
+				interval.start--
+			}
+			if opts.SubExpressionTransformer != nil {
+				var info SubExpressionInfo
+				var err error
+				opts.guardHook(func() { info, err = opts.SubExpressionTransformer(interval.info()) })
+				if err != nil {
+					return nil, opts.errorf(ErrorKindRejected, token, pos, "subexpression %q rejected: %w", intervalStr, err)
+				}
+				notation := interval.notation
+				if interval, err = subExpressionFromInfo(info); err != nil {
+					return nil, opts.errorf(ErrorKindRejected, token, pos, "subexpression %q transformed into invalid one: %w", intervalStr, err)
+				}
+				interval.notation = notation
 			}
 			intervals = append(intervals, interval)
 		}
 	}
+	return intervals, nil
+}
 
-	e := Expression{intervals: intervals, opts: opts}
+// splitSubExpressions is like r.Split(input, -1), but additionally returns
+// the position of each part, counting from offset. If opts.AllowNested is
+// set, delimiters inside parenthesized groups are not split on.
+func splitSubExpressions(input string, offset int, r *regexp.Regexp, opts ParseOptions) ([]string, []int, error) {
+	depth := make([]int, len(input))
+	if opts.AllowNested {
+		d := 0
+		for i := 0; i < len(input); i++ {
+			switch input[i] {
+			case '(':
+				d++
+			case ')':
+				if d--; d < 0 {
+					return nil, nil, opts.errorf(ErrorKindInvalidSyntax, input, offset, "unbalanced parentheses: %q", input)
+				}
+			}
+			depth[i] = d
+		}
+		if d != 0 {
+			return nil, nil, opts.errorf(ErrorKindInvalidSyntax, input, offset, "unbalanced parentheses: %q", input)
+		}
+	}
+	var parts []string
+	var positions []int
+	prev := 0
+	for _, loc := range r.FindAllStringIndex(input, -1) {
+		if depth[loc[0]] == 0 {
+			parts = append(parts, input[prev:loc[0]])
+			positions = append(positions, offset+prev)
+			prev = loc[1]
+		}
+	}
+	return append(parts, input[prev:]), append(positions, offset+prev), nil
+}
 
-	if e.MatchesNone() && !opts.AllowEmptyExpression {
-		return Expression{}, fmt.Errorf("current options prohibit empty expressions")
+// ParseExpressionList parses each of the inputs with ParseExpressionWithOptions()
+// using the same options. The parsed Expressions are returned in the order of
+// the inputs. If any input is invalid, the function returns nil and the error
+// of the first invalid input, annotated with its index.
+func ParseExpressionList(inputs []string, opts ParseOptions) ([]Expression, error) {
+	exprs := make([]Expression, 0, len(inputs))
+	for i, input := range inputs {
+		e, err := ParseExpressionWithOptions(input, opts)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		exprs = append(exprs, e)
 	}
+	return exprs, nil
+}
 
-	if opts.PostProcessNormalize {
-		return e.Normalize(), nil
+// ParseExpressionListAll is like ParseExpressionList, but does not stop at the
+// first invalid input. Instead, it returns an Expression for each input (an
+// empty Expression{} for invalid ones) and the errors of all invalid inputs,
+// each annotated with the index of the input. The error slice is empty if all
+// inputs are valid.
+func ParseExpressionListAll(inputs []string, opts ParseOptions) ([]Expression, []error) {
+	exprs := make([]Expression, len(inputs))
+	var errs []error
+	for i, input := range inputs {
+		e, err := ParseExpressionWithOptions(input, opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("input %d: %w", i, err))
+			continue
+		}
+		exprs[i] = e
 	}
-	return e, nil
+	return exprs, errs
+}
+
+// redundancyWarnings describes each subexpression that is (at least
+// partially) made redundant by another one, reporting only the first such
+// relation found. Of duplicates, the later ones are redundant; of overlapping
+// subexpressions, the later one.
+func redundancyWarnings(intervals []subExpression) []string {
+	var warnings []string
+	for j, b := range intervals {
+		if b.negate {
+			continue
+		}
+	others:
+		for i, a := range intervals {
+			if i == j || a.negate {
+				continue
+			}
+			switch {
+			case a.matchAll == b.matchAll && a.start == b.start && a.count == b.count:
+				if i < j {
+					warnings = append(warnings, fmt.Sprintf("subexpression %q is a duplicate of an earlier one", b))
+					break others
+				}
+			case a.contains(b):
+				warnings = append(warnings, fmt.Sprintf("subexpression %q is contained in %q", b, a))
+				break others
+			case b.contains(a):
+				// a is the redundant one
+			case i < j && a.overlaps(b):
+				warnings = append(warnings, fmt.Sprintf("subexpressions %q and %q overlap", a, b))
+				break others
+			}
+		}
+	}
+	return warnings
 }
 
 var subRegexMatchall = regexp.MustCompile(`^\s*\*\s*$`)
-var subRegexSingle = regexp.MustCompile(`^\s*(?P<start>\d+)\s*$`)
-var subRegexDual = regexp.MustCompile(`^\s*(?P<start>\d+)\s*-\s*(?P<end>\d+)\s*$`)
-var subRegexHalfOpen = regexp.MustCompile(`^\s*(?P<start>\d+)\s*-\s*$`)
+var subRegexSingle = regexp.MustCompile(`^\s*(?P<start>\+?\w[\w.,]*)\s*$`)
+var subRegexDual = regexp.MustCompile(`^\s*(?P<start>\+?\w[\w.,]*)\s*-\s*(?P<end>\+?\w[\w.,]*)\s*$`)
+var subRegexHalfOpen = regexp.MustCompile(`^\s*(?P<start>\+?\w[\w.,]*)\s*-\s*$`)
+
+// localeGroupSeparators maps the primary language subtag of a BCP 47 tag to
+// the digit grouping separator of that language; see ParseOptions.Locale.
+var localeGroupSeparators = map[string]string{
+	"da": ".", "de": ".", "el": ".", "es": ".", "id": ".", "it": ".",
+	"nl": ".", "pt": ".", "ro": ".", "tr": ".",
+	"en": ",", "he": ",", "ja": ",", "ko": ",", "th": ",", "zh": ",",
+}
+
+// localeGroupSeparator returns the digit grouping separator for the BCP 47
+// language tag locale.
+func localeGroupSeparator(locale string) (string, error) {
+	lang := locale
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		lang = locale[:i]
+	}
+	sep, ok := localeGroupSeparators[strings.ToLower(lang)]
+	if !ok {
+		return "", fmt.Errorf("ParseOptions.Locale %q is not supported", locale)
+	}
+	return sep, nil
+}
+
+// stripDigitGrouping removes the digit grouping separators of locale from the
+// value s, checking that the digits are grouped in threes.
+func stripDigitGrouping(s, locale string) (string, error) {
+	sep, err := localeGroupSeparator(locale)
+	if err != nil {
+		return "", err
+	}
+	groups := strings.Split(s, sep)
+	if len(groups) == 1 {
+		return s, nil
+	}
+	for i, g := range groups {
+		if (i == 0 && (len(g) < 1 || len(g) > 3)) || (i > 0 && len(g) != 3) {
+			return "", fmt.Errorf("invalid digit grouping in value %q", s)
+		}
+	}
+	return strings.Join(groups, ""), nil
+}
+
+// prohibitedValueError is returned by parseValue for a value written in valid
+// syntax that the options prohibit, such as "007" with
+// ParseOptions.RejectLeadingZeros, or "+5" without
+// ParseOptions.AllowPrefixPlus.
+type prohibitedValueError struct {
+	msg string
+}
+
+func (e prohibitedValueError) Error() string {
+	return e.msg
+}
+
+// valueErrorKind determines the ErrorKind of an error returned by parseValue.
+func valueErrorKind(err error) ErrorKind {
+	var prohibited prohibitedValueError
+	if errors.As(err, &prohibited) {
+		return ErrorKindProhibited
+	}
+	return ErrorKindInvalidValue
+}
+
+// parseValue parses a single integer value according to opts (see
+// ParseOptions.IntBase), returning also the notation the value was written in.
+func parseValue(s string, opts ParseOptions) (int64, valueNotation, error) {
+	if strings.HasPrefix(s, "+") {
+		if !opts.AllowPrefixPlus {
+			return 0, valueNotation{}, prohibitedValueError{fmt.Sprintf("leading '+' is not allowed: %q", s)}
+		}
+		s = s[1:]
+	}
+	if opts.Locale != "" {
+		var err error
+		if s, err = stripDigitGrouping(s, opts.Locale); err != nil {
+			return 0, valueNotation{}, err
+		}
+	}
+	if opts.RejectLeadingZeros && opts.IntBase != IntBaseAuto && len(s) > 1 && s[0] == '0' {
+		return 0, valueNotation{}, prohibitedValueError{fmt.Sprintf("leading zeros are not allowed: %q", s)}
+	}
+	switch base := opts.IntBase; base {
+	case 0, 10:
+		v, err := strconv.ParseInt(s, 10, 0)
+		return v, valueNotation{}, err
+	case IntBaseAuto:
+		v, err := strconv.ParseInt(s, 0, 0)
+		if err != nil {
+			return 0, valueNotation{}, err
+		}
+		switch lower := strings.ToLower(s); {
+		case strings.HasPrefix(lower, "0x"):
+			return v, valueNotation{base: 16, prefix: s[:2]}, nil
+		case strings.HasPrefix(lower, "0b"):
+			return v, valueNotation{base: 2, prefix: s[:2]}, nil
+		case strings.HasPrefix(lower, "0o"):
+			return v, valueNotation{base: 8, prefix: s[:2]}, nil
+		case len(s) > 1 && s[0] == '0':
+			return v, valueNotation{base: 8, prefix: "0"}, nil
+		}
+		return v, valueNotation{}, nil
+	default:
+		v, err := strconv.ParseInt(s, base, 0)
+		return v, valueNotation{base: base}, err
+	}
+}
 
-func parseSubExpression(subInput string) (subExpression, error) {
+func parseSubExpression(subInput string, pos int, opts ParseOptions) (subExpression, error) {
 	if subRegexMatchall.MatchString(subInput) {
+		if opts.RejectWildcardStar {
+			return subExpression{}, opts.errorf(ErrorKindProhibited, subInput, pos, "current options prohibit wildcard: %q", subInput)
+		}
 		return subExpression{matchAll: true}, nil
 	}
 
 	if m := subRegexSingle.FindStringSubmatch(subInput); m != nil {
 		start := m[subRegexSingle.SubexpIndex("start")]
-		if v, err := strconv.ParseInt(start, 10, 0); err != nil {
-			return subExpression{}, fmt.Errorf("invalid value for interval start: %w", err)
+		if v, n, err := parseValue(start, opts); err != nil {
+			return subExpression{}, opts.errorf(valueErrorKind(err), subInput, pos, "invalid value for interval start: %w", err)
 		} else {
-			return subExpression{start: int(v), count: 1}, nil
+			return subExpression{start: int(v), count: 1, notation: n}, nil
 		}
 	}
 
 	if m := subRegexHalfOpen.FindStringSubmatch(subInput); m != nil {
 		start := m[subRegexHalfOpen.SubexpIndex("start")]
-		if v, err := strconv.ParseInt(start, 10, 0); err != nil {
-			return subExpression{}, fmt.Errorf("invalid value for interval start: %w", err)
+		if v, n, err := parseValue(start, opts); err != nil {
+			return subExpression{}, opts.errorf(valueErrorKind(err), subInput, pos, "invalid value for interval start: %w", err)
 		} else {
-			return subExpression{start: int(v), count: 0}, nil
+			return subExpression{start: int(v), count: 0, notation: n}, nil
 		}
 	}
 
@@ -358,20 +2562,114 @@ func parseSubExpression(subInput string) (subExpression, error) {
 		start := m[subRegexDual.SubexpIndex("start")]
 		end := m[subRegexDual.SubexpIndex("end")]
 		var vStart, vEnd int64
+		var n valueNotation
 		var err error
-		if vStart, err = strconv.ParseInt(start, 10, 0); err != nil {
-			return subExpression{}, fmt.Errorf("invalid value for interval start: %w", err)
+		if vStart, n, err = parseValue(start, opts); err != nil {
+			return subExpression{}, opts.errorf(valueErrorKind(err), subInput, pos, "invalid value for interval start: %w", err)
 		}
-		if vEnd, err = strconv.ParseInt(end, 10, 0); err != nil {
-			return subExpression{}, fmt.Errorf("invalid value for interval end: %w", err)
+		if vEnd, _, err = parseValue(end, opts); err != nil {
+			return subExpression{}, opts.errorf(valueErrorKind(err), subInput, pos, "invalid value for interval end: %w", err)
 		}
 		if vEnd < vStart {
-			return subExpression{}, fmt.Errorf("invalid interval 'a-b' where a > b: %q", subInput)
+			return subExpression{}, opts.errorf(ErrorKindInvalidRange, subInput, pos, "invalid interval 'a-b' where a > b: %q", subInput)
+		}
+		if vEnd == vStart && opts.RejectSingletonAsRange {
+			return subExpression{}, opts.errorf(ErrorKindProhibited, subInput, pos, "current options prohibit single value ranges: %q, write %q instead", subInput, start)
 		}
 		a, b := int(vStart), int(vEnd)
 		c := b - a + 1
-		return subExpression{start: a, count: c}, nil
+		return subExpression{start: a, count: c, notation: n}, nil
 	}
 
-	return subExpression{}, fmt.Errorf("invalid syntax: %q", subInput)
+	return subExpression{}, opts.errorf(ErrorKindInvalidSyntax, subInput, pos, "invalid syntax: %q", subInput)
+}
+
+// RuneNotation specifies how ParseRuneRangeExpression interprets the values in
+// its input string.
+type RuneNotation int
+
+const (
+	// RuneNotationDecimal denotes code points written as plain decimal
+	// integers, e.g "65-90". This is the same syntax as ParseExpression().
+	RuneNotationDecimal RuneNotation = iota
+
+	// RuneNotationUnicode denotes code points written in the conventional
+	// "U+" hexadecimal notation, e.g "U+0041-U+005A".
+	RuneNotationUnicode
+)
+
+var runeRegexUnicode = regexp.MustCompile(`(?i)U\+([0-9A-F]+)\b`)
+
+// ParseRuneRangeExpression parses an expression describing ranges of Unicode
+// code points, such as "U+0041-U+005A,U+0061-", using the default options.
+// The resulting Expression is meant to be evaluated with MatchesRune().
+//
+// Apart from the notation of individual values, the syntax is the same as
+// described in ParseExpressionWithOptions().
+func ParseRuneRangeExpression(input string, notation RuneNotation) (Expression, error) {
+	switch notation {
+	case RuneNotationDecimal:
+		return ParseExpression(input)
+	case RuneNotationUnicode:
+		var convErr error
+		converted := runeRegexUnicode.ReplaceAllStringFunc(input, func(cp string) string {
+			if len(cp) > 2+6 {
+				if convErr == nil {
+					convErr = fmt.Errorf("too many digits in code point: %q", cp)
+				}
+				return cp
+			}
+			// at most 6 hex digits, always fits
+			v, _ := strconv.ParseInt(cp[2:], 16, 32)
+			if v > utf8.MaxRune {
+				if convErr == nil {
+					convErr = fmt.Errorf("code point out of range: %q", cp)
+				}
+				return cp
+			}
+			return strconv.FormatInt(v, 10)
+		})
+		if convErr != nil {
+			return Expression{}, convErr
+		}
+		return ParseExpression(converted)
+	default:
+		return Expression{}, fmt.Errorf("unknown rune notation: %d", notation)
+	}
+}
+
+var timestampRegex = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})`)
+
+// ParseTimestampExpression parses an expression describing intervals of time,
+// such as "2024-01-01T00:00:00Z - 2024-12-31T23:59:59Z,2025-06-01T00:00:00Z-",
+// using the default options. Each value must be an RFC 3339 timestamp, and is
+// converted to Unix time in seconds; the resulting Expression is meant to be
+// evaluated with MatchesTimestamp().
+//
+// Apart from the notation of individual values, the syntax is the same as
+// described in ParseExpressionWithOptions(). Note that timestamps before the
+// Unix epoch are not supported, since the parser does not accept negative
+// values.
+func ParseTimestampExpression(input string) (Expression, error) {
+	var convErr error
+	converted := timestampRegex.ReplaceAllStringFunc(input, func(ts string) string {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			if convErr == nil {
+				convErr = fmt.Errorf("invalid timestamp %q: %w", ts, err)
+			}
+			return ts
+		}
+		if t.Unix() < 0 {
+			if convErr == nil {
+				convErr = fmt.Errorf("timestamp before Unix epoch: %q", ts)
+			}
+			return ts
+		}
+		return strconv.FormatInt(t.Unix(), 10)
+	})
+	if convErr != nil {
+		return Expression{}, convErr
+	}
+	return ParseExpression(converted)
 }
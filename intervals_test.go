@@ -15,9 +15,22 @@
 package integerintervalexpressions
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/quick"
+	"time"
+	"unicode/utf8"
 )
 
 func ExampleParseExpression() {
@@ -43,6 +56,15 @@ func ExampleParseExpression() {
 	// 9: true
 }
 
+func ExampleExpression_Intersect() {
+	pages, _ := ParseExpression("1-10,20-")
+	even, _ := ParseExpression("2,4,6,8,10,12,14,16,18,20,22")
+	selected, _ := ParseExpression("5-21")
+	fmt.Println(pages.Intersect(even).Intersect(selected))
+	// Output:
+	// 6,8,10,20
+}
+
 var testCases = []struct {
 	name      string
 	input     string
@@ -394,184 +416,198 @@ func TestParseExpression(t *testing.T) {
 	}
 }
 
+// normalizeTestOpts are the options of the Expressions in normalizeTests
+var normalizeTestOpts = DefaultParseOptions()
+
+var normalizeTests = []struct {
+	name   string
+	input  Expression
+	expect Expression
+}{
+	{
+		// 0 elements
+		name:   "simple-empty",
+		input:  Expression{},
+		expect: Expression{},
+	},
+	{
+		// 1 element
+		name: "simple-single-individual",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 2, count: 1},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 2, count: 1},
+		}},
+	},
+	{
+		// 3 elements
+		name: "simple-individual-consecutive-ordered",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 1},
+			subExpression{start: 2, count: 1},
+			subExpression{start: 3, count: 1},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 3},
+		}},
+	},
+	{
+		// 3 elements
+		name: "simple-individual-consecutive-ordered-with-gaps",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 1},
+			subExpression{start: 3, count: 1},
+			subExpression{start: 10, count: 1},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 1},
+			subExpression{start: 3, count: 1},
+			subExpression{start: 10, count: 1},
+		}},
+	},
+	{
+		// 3 elements
+		name: "simple-individual-consecutive-non-ordered-with-gaps",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 10, count: 1},
+			subExpression{start: 3, count: 1},
+			subExpression{start: 1, count: 1},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 1},
+			subExpression{start: 3, count: 1},
+			subExpression{start: 10, count: 1},
+		}},
+	},
+	{
+		// 2 elements
+		name: "simple-overlapping-ordered",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 2},
+			subExpression{start: 2, count: 2},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 3},
+		}},
+	},
+	{
+		// 2 elements
+		name: "simple-overlapping-not-ordered",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 2, count: 2},
+			subExpression{start: 1, count: 2},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 3},
+		}},
+	},
+	{
+		// 2 elements
+		name: "simple-disjoint-ordered",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 2},
+			subExpression{start: 4, count: 3},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 2},
+			subExpression{start: 4, count: 3},
+		}},
+	},
+	{
+		// 2 elements
+		name: "simple-overlapping-ordered-unbounded",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 2, count: 3},
+			subExpression{start: 3, count: 0},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 2, count: 0},
+		}},
+	},
+	{
+		// "1,1-" i.e overlapping
+		name: "simple-redundant-overlapping-zeros",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 1},
+			subExpression{start: 0, count: 0},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 0, count: 0},
+		}},
+	},
+	{
+		// "1,1-" i.e overlapping
+		name: "simple-redundant-overlapping",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 2, count: 1},
+			subExpression{start: 2, count: 0},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 2, count: 0},
+		}},
+	},
+	{
+		// 1,5-7,2-,9-10,17-
+		name: "random-complicated-expression",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 1},
+			subExpression{start: 5, count: 2},
+			subExpression{start: 2, count: 0},
+			subExpression{start: 9, count: 2},
+			subExpression{start: 17, count: 0},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 0},
+		}},
+	},
+	{
+		// 1,5-7,2-,9-10,17-
+		name: "match-all-1",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 1, count: 1},
+			subExpression{start: 5, count: 2},
+			subExpression{matchAll: true},
+			subExpression{start: 2, count: 0},
+			subExpression{start: 9, count: 2},
+			subExpression{start: 17, count: 0},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{matchAll: true},
+		}},
+	},
+	{
+		// "3-5,4"  // 4 contained in 3-5
+		name: "simple-contained",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 3, count: 3},
+			subExpression{start: 4, count: 1},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 3, count: 3},
+		}},
+	},
+	{
+		// "2,4-,7"  // redundant 7
+		name: "simple-half-open-redundant-last-value",
+		input: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 2, count: 1},
+			subExpression{start: 4, count: 0},
+			subExpression{start: 7, count: 1},
+		}},
+		expect: Expression{opts: normalizeTestOpts, intervals: []subExpression{
+			subExpression{start: 2, count: 1},
+			subExpression{start: 4, count: 0},
+		}},
+	},
+}
+
 func TestNormalize(t *testing.T) {
 
 	defaultOpts := DefaultParseOptions()
 	fmt.Println("default:", defaultOpts)
 
-	testCases := []struct {
-		name   string
-		input  Expression
-		expect Expression
-	}{
-		{
-			// 0 elements
-			name:   "simple-empty",
-			input:  Expression{},
-			expect: Expression{},
-		},
-		{
-			// 1 element
-			name: "simple-single-individual",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 2, count: 1},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 2, count: 1},
-			}},
-		},
-		{
-			// 3 elements
-			name: "simple-individual-consecutive-ordered",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 1},
-				subExpression{start: 2, count: 1},
-				subExpression{start: 3, count: 1},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 3},
-			}},
-		},
-		{
-			// 3 elements
-			name: "simple-individual-consecutive-ordered-with-gaps",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 1},
-				subExpression{start: 3, count: 1},
-				subExpression{start: 10, count: 1},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 1},
-				subExpression{start: 3, count: 1},
-				subExpression{start: 10, count: 1},
-			}},
-		},
-		{
-			// 3 elements
-			name: "simple-individual-consecutive-non-ordered-with-gaps",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 10, count: 1},
-				subExpression{start: 3, count: 1},
-				subExpression{start: 1, count: 1},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 1},
-				subExpression{start: 3, count: 1},
-				subExpression{start: 10, count: 1},
-			}},
-		},
-		{
-			// 2 elements
-			name: "simple-overlapping-ordered",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 2},
-				subExpression{start: 2, count: 2},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 3},
-			}},
-		},
-		{
-			// 2 elements
-			name: "simple-overlapping-not-ordered",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 2, count: 2},
-				subExpression{start: 1, count: 2},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 3},
-			}},
-		},
-		{
-			// 2 elements
-			name: "simple-disjoint-ordered",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 2},
-				subExpression{start: 4, count: 3},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 2},
-				subExpression{start: 4, count: 3},
-			}},
-		},
-		{
-			// 2 elements
-			name: "simple-overlapping-ordered-unbounded",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 2, count: 3},
-				subExpression{start: 3, count: 0},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 2, count: 0},
-			}},
-		},
-		{
-			// "1,1-" i.e overlapping
-			name: "simple-redundant-overlapping-zeros",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 1},
-				subExpression{start: 0, count: 0},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 0, count: 0},
-			}},
-		},
-		{
-			// "1,1-" i.e overlapping
-			name: "simple-redundant-overlapping",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 2, count: 1},
-				subExpression{start: 2, count: 0},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 2, count: 0},
-			}},
-		},
-		{
-			// 1,5-7,2-,9-10,17-
-			name: "random-complicated-expression",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 1},
-				subExpression{start: 5, count: 2},
-				subExpression{start: 2, count: 0},
-				subExpression{start: 9, count: 2},
-				subExpression{start: 17, count: 0},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 0},
-			}},
-		},
-		{
-			// 1,5-7,2-,9-10,17-
-			name: "match-all-1",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 1, count: 1},
-				subExpression{start: 5, count: 2},
-				subExpression{matchAll: true},
-				subExpression{start: 2, count: 0},
-				subExpression{start: 9, count: 2},
-				subExpression{start: 17, count: 0},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{matchAll: true},
-			}},
-		},
-		{
-			// "2,4-,7"  // redundant 7
-			name: "simple-half-open-redundant-last-value",
-			input: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 2, count: 1},
-				subExpression{start: 4, count: 0},
-				subExpression{start: 7, count: 1},
-			}},
-			expect: Expression{opts: defaultOpts, intervals: []subExpression{
-				subExpression{start: 2, count: 1},
-				subExpression{start: 4, count: 0},
-			}},
-		},
-	}
-
-	for _, test := range testCases {
+	for _, test := range normalizeTests {
 		t.Run(test.name, func(t *testing.T) {
 			got := test.input.Normalize()
 			if !reflect.DeepEqual(test.expect.intervals, got.intervals) {
@@ -593,6 +629,33 @@ func TestNormalizeOptionsPropagate(t *testing.T) {
 	}
 }
 
+func TestNormalizeOptionsPropagateString(t *testing.T) {
+	// each code path of Normalize() must retain the delimiter for String()
+	opts := ParseOptions{Delimiter: ";", AllowEmptyExpression: true}
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{input: "", expect: ""},
+		{input: "1;*", expect: "*"},
+		{input: "5-7;1;2-3", expect: "1-3;5-7"},
+		{input: "5-;1", expect: "1;5-"},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		norm := expr.Normalize()
+		if !reflect.DeepEqual(norm.opts, opts) {
+			t.Errorf("%q: expected opts %#v, got %#v", test.input, opts, norm.opts)
+		}
+		if got := norm.String(); got != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expect, got)
+		}
+	}
+}
+
 func TestExpressionStringer(t *testing.T) {
 	// TODO add better tests
 
@@ -617,6 +680,26 @@ func TestExpressionStringer(t *testing.T) {
 	}
 }
 
+func TestExpressionStringerSingleValueRange(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{input: "5-5", expect: "5"},
+		{input: " 5 - 5 ", expect: "5"},
+		{input: "1-1,3-5,7-7", expect: "1,3-5,7"},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if str := expr.String(); str != test.expect {
+			t.Fatalf("expected: %q, got: %q", test.expect, str)
+		}
+	}
+}
+
 func TestInvalidOptionsMissingDelimiter(t *testing.T) {
 	input := "whatever"
 	opts := DefaultParseOptions()
@@ -627,6 +710,29 @@ func TestInvalidOptionsMissingDelimiter(t *testing.T) {
 	}
 }
 
+func TestExpressionVersion(t *testing.T) {
+	cases := []struct {
+		version   int
+		shouldErr bool
+	}{
+		{version: 0, shouldErr: false},
+		{version: CurrentExpressionVersion, shouldErr: false},
+		{version: CurrentExpressionVersion + 1, shouldErr: true},
+		{version: -1, shouldErr: true},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.ExpressionVersion = test.version
+		_, err := ParseExpressionWithOptions("1,3-5", opts)
+		if test.shouldErr && err == nil {
+			t.Errorf("version %d: expected error, got nil", test.version)
+		}
+		if !test.shouldErr && err != nil {
+			t.Errorf("version %d: unexpected error: %s", test.version, err)
+		}
+	}
+}
+
 func TestPostProcessNormalize(t *testing.T) {
 	input := "2-4,3-5"
 	expect := []subExpression{{start: 2, count: 4}}
@@ -640,3 +746,3100 @@ func TestPostProcessNormalize(t *testing.T) {
 		t.Fatalf("expected: %q, got: %q", expect, expr.intervals)
 	}
 }
+
+func FuzzParseExpression(f *testing.F) {
+	for _, test := range testCases {
+		f.Add(test.input)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		expr, err := ParseExpression(input)
+		if err != nil {
+			return
+		}
+		norm := expr.Normalize()
+		str := norm.String()
+		again, err := ParseExpression(str)
+		if err != nil {
+			t.Fatalf("normalized expression %q (from %q) failed to parse: %v", str, input, err)
+		}
+		if got := again.Normalize(); !reflect.DeepEqual(norm.intervals, got.intervals) {
+			t.Fatalf("round-trip mismatch for %q:\nexpect:\n\t%#v\ngot:\n\t%#v", input, norm.intervals, got.intervals)
+		}
+	})
+}
+
+// encodeSubExpressions serializes intervals for FuzzNormalize, three bytes
+// per subexpression: the kind (0 bounded, 1 half-open, 2 "*"), the start as
+// int8, and the count minus one. Values outside of that range are truncated.
+func encodeSubExpressions(intervals []subExpression) []byte {
+	var data []byte
+	for _, itv := range intervals {
+		switch {
+		case itv.matchAll:
+			data = append(data, 2, 0, 0)
+		case itv.count == 0:
+			data = append(data, 1, byte(int8(itv.start)), 0)
+		default:
+			data = append(data, 0, byte(int8(itv.start)), byte(itv.count-1))
+		}
+	}
+	return data
+}
+
+// decodeSubExpressions is the inverse of encodeSubExpressions.
+func decodeSubExpressions(data []byte) []subExpression {
+	var intervals []subExpression
+	for ; len(data) >= 3; data = data[3:] {
+		start := int(int8(data[1]))
+		switch data[0] % 3 {
+		case 0:
+			intervals = append(intervals, subExpression{start: start, count: int(data[2]) + 1})
+		case 1:
+			intervals = append(intervals, subExpression{start: start})
+		case 2:
+			intervals = append(intervals, subExpression{matchAll: true})
+		}
+	}
+	return intervals
+}
+
+func FuzzNormalize(f *testing.F) {
+	for _, test := range normalizeTests {
+		f.Add(encodeSubExpressions(test.input.intervals))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		expr := Expression{intervals: decodeSubExpressions(data), opts: DefaultParseOptions()}
+		norm := expr.Normalize()
+		if again := norm.Normalize(); !reflect.DeepEqual(norm.intervals, again.intervals) {
+			t.Fatalf("Normalize() not idempotent for %q:\nfirst:\n\t%#v\nsecond:\n\t%#v", expr, norm.intervals, again.intervals)
+		}
+		for val := -300; val <= 300; val++ {
+			if expr.Matches(val) != norm.Matches(val) {
+				t.Fatalf("Matches(%d) differs for %q and normalized %q", val, expr, norm)
+			}
+		}
+		for _, val := range []int{math.MinInt, math.MaxInt} {
+			if expr.Matches(val) != norm.Matches(val) {
+				t.Fatalf("Matches(%d) differs for %q and normalized %q", val, expr, norm)
+			}
+		}
+	})
+}
+
+func TestIsConnected(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect bool
+	}{
+		{input: "1-5", expect: true},
+		{input: "3", expect: true},
+		{input: "3-", expect: true},
+		{input: "*", expect: true},
+		{input: "1-3,4-6", expect: true},
+		{input: "5-7,1-4", expect: true},
+		{input: "1-3,2-", expect: true},
+		{input: "1-3,5-7", expect: false},
+		{input: "1,3", expect: false},
+		{input: "1,3-", expect: false},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.IsConnected(); got != test.expect {
+			t.Errorf("%q: expected IsConnected() == %v, got %v", test.input, test.expect, got)
+		}
+		if got := expr.String(); got != test.input {
+			t.Errorf("IsConnected() modified the expression: expected %q, got %q", test.input, got)
+		}
+	}
+
+	empty, err := ParseExpressionWithOptions("", ParseOptions{Delimiter: ",", AllowEmptyExpression: true})
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if empty.IsConnected() {
+		t.Errorf("expected empty expression not to be connected")
+	}
+}
+
+// randomExpression constructs an Expression of random (possibly overlapping,
+// unordered, half-open and/or matchAll) subexpressions.
+func randomExpression(rng *rand.Rand) Expression {
+	n := rng.Intn(10)
+	intervals := make([]subExpression, 0, n)
+	for i := 0; i < n; i++ {
+		switch r := rng.Intn(20); {
+		case r == 0:
+			intervals = append(intervals, subExpression{matchAll: true})
+		case r < 3:
+			intervals = append(intervals, subExpression{start: rng.Intn(100), count: 0})
+		default:
+			intervals = append(intervals, subExpression{start: rng.Intn(100), count: 1 + rng.Intn(10)})
+		}
+	}
+	return Expression{intervals: intervals, opts: DefaultParseOptions()}
+}
+
+func TestNormalizedStringDeterministic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		expr := randomExpression(rng)
+		expect := expr.Normalize().String()
+		for j := 0; j < 10; j++ {
+			// permute the subexpressions, and duplicate some of them
+			var intervals []subExpression
+			for _, k := range rng.Perm(len(expr.intervals)) {
+				intervals = append(intervals, expr.intervals[k])
+				if rng.Intn(4) == 0 {
+					intervals = append(intervals, expr.intervals[k])
+				}
+			}
+			other := Expression{intervals: intervals, opts: expr.opts}
+			if got := other.Normalize().String(); got != expect {
+				t.Fatalf("normalized String() differs for %q and %q: %q vs %q", expr, other, expect, got)
+			}
+		}
+	}
+}
+
+func TestNormalizeIdempotent(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		expr := randomExpression(rng)
+		once := expr.Normalize()
+		twice := once.Normalize()
+		if !reflect.DeepEqual(once, twice) {
+			t.Fatalf("Normalize() is not idempotent\nInput:\n\t%v\nOnce:\n\t%v\nTwice:\n\t%v", expr, once, twice)
+		}
+	}
+}
+
+func TestMatchesRange(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,10-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	cases := []struct {
+		lo, hi int
+		expect bool
+	}{
+		{lo: 0, hi: 0, expect: false},
+		{lo: 0, hi: 1, expect: true},
+		{lo: 2, hi: 2, expect: false},
+		{lo: 2, hi: 3, expect: true},
+		{lo: 4, hi: 4, expect: true},
+		{lo: 5, hi: 9, expect: true},
+		{lo: 6, hi: 9, expect: false},
+		{lo: 6, hi: 10, expect: true},
+		{lo: 1000, hi: 2000, expect: true},
+		{lo: -10, hi: 100, expect: true},
+		{lo: 4, hi: 3, expect: false},
+	}
+	for _, test := range cases {
+		if got := expr.MatchesRange(test.lo, test.hi); got != test.expect {
+			t.Errorf("MatchesRange(%d, %d): expected %v, got %v", test.lo, test.hi, test.expect, got)
+		}
+	}
+
+	all, err := ParseExpression("*")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if !all.MatchesRange(-5, -1) {
+		t.Errorf("expected '*' to match any range")
+	}
+}
+
+func TestIntervalCountWarning(t *testing.T) {
+	cases := []struct {
+		input     string
+		threshold int
+		normalize bool
+		expectN   int
+	}{
+		{input: "1,2,3", threshold: 0, expectN: 0},
+		{input: "1,2,3", threshold: 3, expectN: 0},
+		{input: "1,2,3", threshold: 2, expectN: 1},
+		{input: "1,2,3", threshold: 2, normalize: true, expectN: 1},
+		{input: "1,,,2", threshold: 2, expectN: 0},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.IntervalCountWarning = test.threshold
+		opts.PostProcessNormalize = test.normalize
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if n := len(expr.Warnings()); n != test.expectN {
+			t.Errorf("%q (threshold %d): expected %d warnings, got %d: %q",
+				test.input, test.threshold, test.expectN, n, expr.Warnings())
+		}
+	}
+}
+
+func TestMatchesAllOf(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	cases := []struct {
+		vals   []int
+		expect bool
+	}{
+		{vals: nil, expect: true},
+		{vals: []int{1}, expect: true},
+		{vals: []int{1, 3, 4, 5, 7, 100}, expect: true},
+		{vals: []int{1, 2}, expect: false},
+		{vals: []int{6}, expect: false},
+	}
+	for _, test := range cases {
+		if got := expr.MatchesAllOf(test.vals...); got != test.expect {
+			t.Errorf("MatchesAllOf(%v): expected %v, got %v", test.vals, test.expect, got)
+		}
+	}
+}
+
+func TestMatchesAnyOf(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	cases := []struct {
+		vals   []int
+		expect bool
+	}{
+		{vals: nil, expect: false},
+		{vals: []int{1}, expect: true},
+		{vals: []int{0, 2, 6, 100}, expect: true},
+		{vals: []int{0, 2, 6}, expect: false},
+	}
+	for _, test := range cases {
+		if got := expr.MatchesAnyOf(test.vals...); got != test.expect {
+			t.Errorf("MatchesAnyOf(%v): expected %v, got %v", test.vals, test.expect, got)
+		}
+	}
+}
+
+func TestMatchesMany(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	cases := []struct {
+		vals   []int
+		expect int
+	}{
+		{vals: nil, expect: 0},
+		{vals: []int{0, 2, 6}, expect: 0},
+		{vals: []int{0, 1, 2, 3, 4, 5, 6, 7, 8}, expect: 6},
+		{vals: []int{1, 1, 1}, expect: 3},
+	}
+	for _, test := range cases {
+		if got := expr.MatchesMany(test.vals); got != test.expect {
+			t.Errorf("MatchesMany(%v): expected %d, got %d", test.vals, test.expect, got)
+		}
+	}
+}
+
+func TestMatchesIndexedMap(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	input := map[int]string{0: "a", 1: "b", 2: "c", 4: "d", 6: "e", 9: "f"}
+	expect := map[int]string{1: "b", 4: "d", 9: "f"}
+	if got := expr.MatchesIndexedMap(input); !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expected: %v, got: %v", expect, got)
+	}
+	if len(input) != 6 {
+		t.Fatalf("input map was modified: %v", input)
+	}
+}
+
+func TestCountSubExpressionsOfType(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,*,7-,9,10-12,13-13,20-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	singletons, ranges, halfOpen, matchAll := expr.CountSubExpressionsOfType()
+	if singletons != 3 || ranges != 2 || halfOpen != 2 || matchAll != 1 {
+		t.Fatalf("expected (3, 2, 2, 1), got (%d, %d, %d, %d)", singletons, ranges, halfOpen, matchAll)
+	}
+}
+
+// benchInput returns an expression string of n disjoint subexpressions of
+// mixed kinds, e.g "0,3-5,9,12-14,...,<last>-"
+func benchInput(n int) string {
+	var input string
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			input += ","
+		}
+		start := i * 4
+		switch {
+		case i == n-1:
+			input += fmt.Sprintf("%d-", start)
+		case i%2 == 0:
+			input += fmt.Sprintf("%d", start)
+		default:
+			input += fmt.Sprintf("%d-%d", start, start+2)
+		}
+	}
+	return input
+}
+
+func BenchmarkParseExpression(b *testing.B) {
+	input := benchInput(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseExpression(input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExpressionString(b *testing.B) {
+	expr, err := ParseExpression(benchInput(100))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = expr.String()
+	}
+}
+
+func BenchmarkNormalize(b *testing.B) {
+	expr, err := ParseExpression(benchInput(100))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = expr.Normalize()
+	}
+}
+
+func BenchmarkMatches(b *testing.B) {
+	expr, err := ParseExpression(benchInput(100))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = expr.Matches(i % 500)
+	}
+}
+
+// roundTripInput is a random valid expression string, possibly containing
+// superfluous whitespace and empty subexpressions.
+type roundTripInput string
+
+func (roundTripInput) Generate(rng *rand.Rand, size int) reflect.Value {
+	ws := func() string {
+		return []string{"", "", " ", "  ", "\t"}[rng.Intn(5)]
+	}
+	n := 1 + rng.Intn(size+1)
+	var input string
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			input += ws() + "," + ws()
+		}
+		start := rng.Intn(1000)
+		switch r := rng.Intn(20); {
+		case r == 0:
+			input += "*"
+		case r == 1:
+			// empty subexpression
+		case r < 5:
+			input += fmt.Sprintf("%d%s-", start, ws())
+		case r < 12:
+			input += fmt.Sprintf("%d", start)
+		default:
+			input += fmt.Sprintf("%d%s-%s%d", start, ws(), ws(), start+rng.Intn(100))
+		}
+	}
+	return reflect.ValueOf(roundTripInput(input))
+}
+
+func TestExpressionStringRoundTrip(t *testing.T) {
+	roundTrip := func(input roundTripInput) bool {
+		expr, err := ParseExpression(string(input))
+		if err != nil {
+			// only happens if the generator produced nothing but empty subexpressions
+			return true
+		}
+		again, err := ParseExpression(expr.String())
+		if err != nil {
+			t.Logf("%q -> %q: %v", input, expr.String(), err)
+			return false
+		}
+		return reflect.DeepEqual(expr.intervals, again.intervals)
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatchesRune(t *testing.T) {
+	cases := []struct {
+		input    string
+		notation RuneNotation
+	}{
+		{input: "65-90,97-", notation: RuneNotationDecimal},
+		{input: "U+0041-U+005A,u+61-", notation: RuneNotationUnicode},
+	}
+	for _, test := range cases {
+		expr, err := ParseRuneRangeExpression(test.input, test.notation)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		for _, r := range "AMZaz€" {
+			if !expr.MatchesRune(r) {
+				t.Errorf("%q: expected match for %q", test.input, r)
+			}
+		}
+		for _, r := range "@[`0 " {
+			if expr.MatchesRune(r) {
+				t.Errorf("%q: expected no match for %q", test.input, r)
+			}
+		}
+	}
+
+	for _, input := range []string{"U+0041-U+", "U+00G1", "A-Z", "U+1234567", "U+0041-U+0001234", "U+110000", "U+10FFFF-U+FFFFFF"} {
+		if _, err := ParseRuneRangeExpression(input, RuneNotationUnicode); err == nil {
+			t.Errorf("%q: expected error, got nil", input)
+		}
+	}
+	if _, err := ParseRuneRangeExpression("1", RuneNotation(42)); err == nil {
+		t.Errorf("expected error for unknown notation, got nil")
+	}
+	expr, err := ParseRuneRangeExpression("U+10FFFF", RuneNotationUnicode)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if !expr.MatchesRune(utf8.MaxRune) {
+		t.Errorf("expected match for U+10FFFF")
+	}
+}
+
+// benchLargeExpression constructs a non-normalized Expression of n
+// overlapping, unordered subexpressions covering roughly half of [0, 20*n).
+func benchLargeExpression(n int) Expression {
+	rng := rand.New(rand.NewSource(1))
+	intervals := make([]subExpression, 0, n)
+	for i := 0; i < n; i++ {
+		intervals = append(intervals, subExpression{start: rng.Intn(20 * n), count: 1 + rng.Intn(10)})
+	}
+	return Expression{intervals: intervals, opts: DefaultParseOptions()}
+}
+
+// benchMatchesValues returns pseudo-random query values, so that the matching
+// and non-matching cases are interleaved unpredictably.
+func benchMatchesValues(n, max int) []int {
+	rng := rand.New(rand.NewSource(2))
+	vals := make([]int, n)
+	for i := range vals {
+		vals[i] = rng.Intn(max)
+	}
+	return vals
+}
+
+func BenchmarkMatches_LargeNonNormalizedExpression(b *testing.B) {
+	expr := benchLargeExpression(1000)
+	vals := benchMatchesValues(4096, 20*1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = expr.Matches(vals[i%len(vals)])
+	}
+}
+
+func BenchmarkMatches_NormalizedExpression(b *testing.B) {
+	expr := benchLargeExpression(1000).Normalize()
+	vals := benchMatchesValues(4096, 20*1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = expr.Matches(vals[i%len(vals)])
+	}
+}
+
+// benchSortedExpression constructs a normalized Expression of n disjoint
+// subexpressions starting from 1000, along with an otherwise identical one
+// not marked as sorted.
+func benchSortedExpression(n int) (sorted, unsorted Expression) {
+	intervals := make([]subExpression, 0, n)
+	for i := 0; i < n; i++ {
+		intervals = append(intervals, subExpression{start: 1000 + 20*i, count: 10})
+	}
+	sorted = Expression{intervals: intervals, opts: DefaultParseOptions()}.Normalize()
+	unsorted = sorted
+	unsorted.isSorted = false
+	return sorted, unsorted
+}
+
+func BenchmarkMatches_SortedExpressionFarLeft(b *testing.B) {
+	expr, _ := benchSortedExpression(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = expr.Matches(5)
+	}
+}
+
+func BenchmarkMatches_UnsortedExpressionFarLeft(b *testing.B) {
+	_, expr := benchSortedExpression(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = expr.Matches(5)
+	}
+}
+
+func TestMatchesSortedEarlyExit(t *testing.T) {
+	expr := benchLargeExpression(100)
+	norm := expr.Normalize()
+	if !norm.isSorted {
+		t.Fatalf("expected Normalize() to mark the result as sorted")
+	}
+	for val := -5; val < 20*100+20; val++ {
+		if got, want := norm.Matches(val), expr.Matches(val); got != want {
+			t.Errorf("Matches(%d): expected %v, got %v", val, want, got)
+		}
+	}
+
+	// a custom sort order must not be mistaken for the normalized one
+	opts := DefaultParseOptions()
+	opts.PostProcessNormalize = true
+	opts.SubExpressionSort = func(a, b SubExpressionInfo) bool { return a.Start > b.Start }
+	desc, err := ParseExpressionWithOptions("1-3,7,10-", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	for _, val := range []int{1, 7, 12} {
+		if !desc.Matches(val) {
+			t.Errorf("%q: expected Matches(%d) to be true", desc.String(), val)
+		}
+	}
+}
+
+func TestMatchesTimestamp(t *testing.T) {
+	expr, err := ParseTimestampExpression("2024-01-01T00:00:00Z - 2024-12-31T23:59:59Z,2030-01-01T00:00:00+02:00-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	unix := func(s string) int64 {
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts.Unix()
+	}
+	cases := []struct {
+		ts     int64
+		expect bool
+	}{
+		{ts: unix("2023-12-31T23:59:59Z"), expect: false},
+		{ts: unix("2024-01-01T00:00:00Z"), expect: true},
+		{ts: unix("2024-07-15T12:00:00Z"), expect: true},
+		{ts: unix("2024-12-31T23:59:59Z"), expect: true},
+		{ts: unix("2025-01-01T00:00:00Z"), expect: false},
+		{ts: unix("2029-12-31T21:59:59Z"), expect: false},
+		{ts: unix("2029-12-31T22:00:00Z"), expect: true},
+		{ts: unix("2100-01-01T00:00:00Z"), expect: true},
+	}
+	for _, test := range cases {
+		if got := expr.MatchesTimestamp(test.ts); got != test.expect {
+			t.Errorf("MatchesTimestamp(%s): expected %v, got %v", time.Unix(test.ts, 0).UTC(), test.expect, got)
+		}
+	}
+
+	for _, input := range []string{"2024-13-01T00:00:00Z", "1969-12-31T23:59:59Z", "2024-01-01"} {
+		if _, err := ParseTimestampExpression(input); err == nil {
+			t.Errorf("%q: expected error, got nil", input)
+		}
+	}
+}
+
+func TestAutoExtendToMatchAll(t *testing.T) {
+	cases := []struct {
+		input     string
+		extend    bool
+		normalize bool
+		expect    string
+	}{
+		{input: "0-", extend: false, expect: "0-"},
+		{input: "0-", extend: true, expect: "*"},
+		{input: "0-", extend: true, normalize: true, expect: "*"},
+		{input: "3-,0-5", extend: true, expect: "*"},
+		{input: "3-,0-5", extend: false, normalize: true, expect: "0-"},
+		{input: "3-,0-1", extend: true, expect: "3-,0-1"},
+		{input: "1-", extend: true, normalize: true, expect: "1-"},
+		{input: "10-,0-4,2,5-9", extend: true, normalize: true, expect: "*"},
+		{input: "10-,0-4,2,6-9", extend: true, normalize: true, expect: "0-4,6-"},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.AutoExtendToMatchAll = test.extend
+		opts.PostProcessNormalize = test.normalize
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.String(); got != test.expect {
+			t.Errorf("%q (extend=%v, normalize=%v): expected %q, got %q",
+				test.input, test.extend, test.normalize, test.expect, got)
+		}
+		if a, b := test.expect == "*", expr.MatchesAll(); a != b {
+			t.Errorf("%q: expected MatchesAll() == %v, got %v", test.input, a, b)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	a, err := ParseExpression("3-5,1,4")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	b, err := ParseExpressionWithOptions("1;3-5", ParseOptions{Delimiter: ";"})
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	c, err := ParseExpression("1,3-6")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if a.Key() != "1,3-5" {
+		t.Errorf("expected key %q, got %q", "1,3-5", a.Key())
+	}
+	cache := map[string]int{a.Key(): 1}
+	cache[b.Key()]++
+	cache[c.Key()]++
+	if len(cache) != 2 || cache[a.Key()] != 2 {
+		t.Fatalf("unexpected cache contents: %v", cache)
+	}
+	if a.String() != "3-5,1,4" {
+		t.Fatalf("Key() modified the expression: %q", a.String())
+	}
+
+	// the notation of the values does not affect the key
+	opts := DefaultParseOptions()
+	opts.IntBase = IntBaseAuto
+	for _, input := range []string{"0x1-0x5", "0b1,0o2-3,0x4-5", "1-5"} {
+		d, err := ParseExpressionWithOptions(input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if d.Key() != "1-5" {
+			t.Errorf("%q: expected key %q, got %q", input, "1-5", d.Key())
+		}
+	}
+}
+
+func TestMatchesNegative(t *testing.T) {
+	// the parser does not produce negative intervals (yet), so construct one
+	// directly: "-5--1,3"
+	expr := Expression{opts: DefaultParseOptions(), intervals: []subExpression{
+		{start: -5, count: 5},
+		{start: 3, count: 1},
+	}}
+	for _, val := range []int{-5, -3, -1, 3} {
+		if !expr.Matches(val) {
+			t.Errorf("expected %d to match", val)
+		}
+	}
+	for _, val := range []int{-6, 0, 2, 4} {
+		if expr.Matches(val) {
+			t.Errorf("expected %d not to match", val)
+		}
+	}
+
+	parsed, err := ParseExpression("0-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if parsed.Matches(-1) {
+		t.Errorf("expected '0-' not to match -1")
+	}
+}
+
+func TestIntBase(t *testing.T) {
+	cases := []struct {
+		input      string
+		base       int
+		shouldErr  bool
+		expect     []subExpression
+		expectText string
+	}{
+		{input: "010-017", base: 0, expect: []subExpression{{start: 10, count: 8}}, expectText: "10-17"},
+		{input: "010-017", base: 10, expect: []subExpression{{start: 10, count: 8}}, expectText: "10-17"},
+		{input: "ff,1A-", base: 16,
+			expect: []subExpression{
+				{start: 255, count: 1, notation: valueNotation{base: 16}},
+				{start: 26, count: 0, notation: valueNotation{base: 16}},
+			},
+			expectText: "ff,1a-"},
+		{input: "101-111", base: 2, expect: []subExpression{{start: 5, count: 3, notation: valueNotation{base: 2}}}, expectText: "101-111"},
+		{input: "0x10-0X1f,010-017,0b1,0o7-,12,1_000", base: IntBaseAuto,
+			expect: []subExpression{
+				{start: 16, count: 16, notation: valueNotation{base: 16, prefix: "0x"}},
+				{start: 8, count: 8, notation: valueNotation{base: 8, prefix: "0"}},
+				{start: 1, count: 1, notation: valueNotation{base: 2, prefix: "0b"}},
+				{start: 7, count: 0, notation: valueNotation{base: 8, prefix: "0o"}},
+				{start: 12, count: 1},
+				{start: 1000, count: 1},
+			},
+			expectText: "0x10-0x1f,010-017,0b1,0o7-,12,1000"},
+		{input: "ff", base: 10, shouldErr: true},
+		{input: "0x10", base: 16, shouldErr: true},
+		{input: "1", base: 1, shouldErr: true},
+		{input: "1", base: 37, shouldErr: true},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.IntBase = test.base
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("%q (base %d): expected error, got nil", test.input, test.base)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q (base %d): unexpected error from parser: %s", test.input, test.base, err)
+		}
+		if !reflect.DeepEqual(test.expect, expr.intervals) {
+			t.Errorf("%q (base %d): expected:\n\t%#v\ngot:\n\t%#v", test.input, test.base, test.expect, expr.intervals)
+		}
+		if got := expr.String(); got != test.expectText {
+			t.Errorf("%q (base %d): expected String() == %q, got %q", test.input, test.base, test.expectText, got)
+		}
+	}
+}
+
+func TestMatchesUint(t *testing.T) {
+	cases := []struct {
+		input  string
+		val    uint
+		expect bool
+	}{
+		{input: "1,3-5", val: 4, expect: true},
+		{input: "1,3-5", val: 2, expect: false},
+		{input: "1,3-5", val: math.MaxInt + 1, expect: false},
+		{input: "1,3-", val: math.MaxInt + 1, expect: true},
+		{input: "1,*", val: math.MaxUint, expect: true},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.MatchesUint(test.val); got != test.expect {
+			t.Errorf("%q: MatchesUint(%d): expected %v, got %v", test.input, test.val, test.expect, got)
+		}
+	}
+}
+
+func TestForEach(t *testing.T) {
+	cases := []struct {
+		input  string
+		lo, hi int
+		expect []int
+	}{
+		{input: "7-,1,3-5", lo: 0, hi: 9, expect: []int{1, 3, 4, 5, 7, 8, 9}},
+		{input: "7-,1,3-5", lo: 4, hi: 4, expect: []int{4}},
+		{input: "7-,1,3-5", lo: 2, hi: 2, expect: nil},
+		{input: "7-,1,3-5", lo: 9, hi: 3, expect: nil},
+		{input: "1,*", lo: -2, hi: 2, expect: []int{-2, -1, 0, 1, 2}},
+		{input: "5-", lo: math.MaxInt - 1, hi: math.MaxInt, expect: []int{math.MaxInt - 1, math.MaxInt}},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		var got []int
+		expr.ForEach(test.lo, test.hi, func(val int) {
+			got = append(got, val)
+		})
+		if !reflect.DeepEqual(test.expect, got) {
+			t.Errorf("%q [%d, %d]: expected %v, got %v", test.input, test.lo, test.hi, test.expect, got)
+		}
+	}
+}
+
+func TestForEachWhile(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	var got []int
+	expr.ForEachWhile(0, 100, func(val int) bool {
+		got = append(got, val)
+		return len(got) < 4
+	})
+	if expect := []int{1, 3, 4, 5}; !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestMatchesInt64(t *testing.T) {
+	cases := []struct {
+		input  string
+		val    int64
+		expect bool
+	}{
+		{input: "1,3-5", val: 4, expect: true},
+		{input: "1,3-5", val: 2, expect: false},
+		{input: "1,3-5", val: math.MaxInt64, expect: false},
+		{input: "1,3-", val: math.MaxInt64, expect: true},
+		{input: "1,3-", val: math.MinInt64, expect: false},
+		{input: "1,*", val: math.MinInt64, expect: true},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.MatchesInt64(test.val); got != test.expect {
+			t.Errorf("%q: MatchesInt64(%d): expected %v, got %v", test.input, test.val, test.expect, got)
+		}
+	}
+}
+
+func TestZipWith(t *testing.T) {
+	and := func(x, y bool) bool { return x && y }
+	or := func(x, y bool) bool { return x || y }
+	xor := func(x, y bool) bool { return x != y }
+	none := func(x, y bool) bool { return !x && !y }
+
+	cases := []struct {
+		a, b   string
+		fn     func(bool, bool) bool
+		lo, hi int
+		expect string
+	}{
+		{a: "1-5,9", b: "4-10", fn: and, lo: 0, hi: 20, expect: "4-5,9"},
+		{a: "1-5,9", b: "4-10", fn: or, lo: 0, hi: 20, expect: "1-10"},
+		{a: "1-5,9", b: "4-10", fn: xor, lo: 0, hi: 20, expect: "1-3,6-8,10"},
+		{a: "1-5,9", b: "4-10", fn: none, lo: 0, hi: 20, expect: "0,11-20"},
+		{a: "1-5,9", b: "4-10", fn: or, lo: 3, hi: 7, expect: "3-7"},
+		{a: "5-", b: "*", fn: xor, lo: -3, hi: 100, expect: "-3-4"},
+		{a: "5-", b: "1", fn: or, lo: 0, hi: math.MaxInt, expect: "1,5-" + fmt.Sprint(math.MaxInt)},
+		{a: "1-5", b: "4-10", fn: and, lo: 5, hi: 4, expect: ""},
+	}
+	for _, test := range cases {
+		a, err := ParseExpression(test.a)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		b, err := ParseExpression(test.b)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		got := a.ZipWith(b, test.fn, test.lo, test.hi)
+		if got.String() != test.expect {
+			t.Errorf("%q, %q [%d, %d]: expected %q, got %q", test.a, test.b, test.lo, test.hi, test.expect, got.String())
+		}
+		for v := test.lo - 2; v <= test.lo+30 && v >= test.lo-2; v++ {
+			inRange := v >= test.lo && v <= test.hi
+			if want := inRange && test.fn(a.Matches(v), b.Matches(v)); got.Matches(v) != want {
+				t.Errorf("%q, %q: Matches(%d): expected %v, got %v", test.a, test.b, v, want, got.Matches(v))
+			}
+		}
+	}
+}
+
+func TestWarnHandler(t *testing.T) {
+	cases := []struct {
+		input   string
+		expectN int
+	}{
+		{input: "1,3-5,7-", expectN: 0},
+		{input: "1-3,4-6", expectN: 0},
+		{input: "1,1", expectN: 1},
+		{input: "3-5,4", expectN: 1},
+		{input: "4,3-5", expectN: 1},
+		{input: "3-5,5-7", expectN: 1},
+		{input: "3-5,5-", expectN: 1},
+		{input: "7-,3-", expectN: 1},
+		{input: "1,*", expectN: 1},
+		{input: "1,3-5,4,2-", expectN: 2},
+		{input: "3,3,3", expectN: 2},
+	}
+	for _, test := range cases {
+		var got []string
+		opts := DefaultParseOptions()
+		opts.WarnHandler = func(msg string) {
+			got = append(got, msg)
+		}
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if len(got) != test.expectN {
+			t.Errorf("%q: expected %d warnings, got %d: %q", test.input, test.expectN, len(got), got)
+		}
+		if !reflect.DeepEqual(got, expr.Warnings()) {
+			t.Errorf("%q: expected Warnings() == %q, got %q", test.input, got, expr.Warnings())
+		}
+	}
+
+	var got []string
+	opts := DefaultParseOptions()
+	opts.IntervalCountWarning = 1
+	opts.WarnHandler = func(msg string) {
+		got = append(got, msg)
+	}
+	if _, err := ParseExpressionWithOptions("1,3", opts); err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("expected the count warning to be passed to WarnHandler, got %q", got)
+	}
+
+	// redundancy is reported regardless of WarnHandler
+	expr, err := ParseExpression("3,3,3,2-4")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if n := len(expr.Warnings()); n != 3 {
+		t.Errorf("expected 3 warnings without WarnHandler, got %d: %q", n, expr.Warnings())
+	}
+}
+
+func TestAdjacent(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect bool
+	}{
+		{a: "1-5", b: "6-10", expect: true},
+		{a: "6-10", b: "1-5", expect: true},
+		{a: "1-5", b: "6-", expect: true},
+		{a: "1-3,7-9", b: "4-6", expect: true},
+		{a: "1", b: "2", expect: true},
+		{a: "1-5", b: "5-10", expect: false},
+		{a: "1-5", b: "7-10", expect: false},
+		{a: "1-3,7-9", b: "4", expect: false},
+		{a: "1-5", b: "*", expect: false},
+	}
+	for _, test := range cases {
+		a, err := ParseExpression(test.a)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		b, err := ParseExpression(test.b)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := a.Adjacent(b); got != test.expect {
+			t.Errorf("%q, %q: expected Adjacent() == %v, got %v", test.a, test.b, test.expect, got)
+		}
+	}
+}
+
+func TestMatchesWithContext(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		got, err := expr.MatchesWithContext(context.Background(), i)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if want := expr.Matches(i); got != want {
+			t.Errorf("MatchesWithContext(%d): expected %v, got %v", i, want, got)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := benchLargeExpression(5000).MatchesWithContext(ctx, -1); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+
+	// a scan that completed is not turned into an error by a late cancellation
+	late := &lateCancelContext{Context: context.Background()}
+	if got, err := expr.MatchesWithContext(late, 2); got || err != nil {
+		t.Errorf("expected (false, nil) after a completed scan, got (%v, %v)", got, err)
+	}
+
+	opts := DefaultParseOptions()
+	opts.AllowNegation = true
+	negated, err := ParseExpressionWithOptions("1-10,^5", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	for i := 0; i < 12; i++ {
+		got, err := negated.MatchesWithContext(context.Background(), i)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if want := negated.Matches(i); got != want {
+			t.Errorf("%q: MatchesWithContext(%d): expected %v, got %v", negated.String(), i, want, got)
+		}
+	}
+}
+
+// lateCancelContext reports cancellation from the second call to Err() on,
+// as if it was cancelled during a scan.
+type lateCancelContext struct {
+	context.Context
+	calls int
+}
+
+func (c *lateCancelContext) Err() error {
+	c.calls++
+	if c.calls > 1 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestUnion(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect string
+	}{
+		{a: "1-3", b: "5-7", expect: "1-3,5-7"},
+		{a: "5-7", b: "1-3,4", expect: "1-7"},
+		{a: "1-3", b: "2-", expect: "1-"},
+		{a: "1-3", b: "*", expect: "*"},
+		{a: "1-3", b: "1-3", expect: "1-3"},
+	}
+	for _, test := range cases {
+		a, err := ParseExpression(test.a)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		b, err := ParseExpression(test.b)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := Union(a, b).String(); got != test.expect {
+			t.Errorf("Union(%q, %q): expected %q, got %q", test.a, test.b, test.expect, got)
+		}
+		if got := a.Merge(b).String(); got != test.expect {
+			t.Errorf("%q.Merge(%q): expected %q, got %q", test.a, test.b, test.expect, got)
+		}
+	}
+
+	empty, err := ParseExpressionWithOptions("", ParseOptions{Delimiter: ",", AllowEmptyExpression: true})
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	a, err := ParseExpression("1,3")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if got := empty.Merge(a).Merge(empty).String(); got != "1,3" {
+		t.Errorf("expected %q, got %q", "1,3", got)
+	}
+
+	// Merge must not modify the receiver
+	b, err := ParseExpression("5-7,2")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	b.Merge(a)
+	if got := b.String(); got != "5-7,2" {
+		t.Errorf("Merge() modified the receiver: %q", got)
+	}
+}
+
+func TestToRegexp(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,10-12")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	for _, base := range []int{2, 10, 16} {
+		re, err := expr.ToRegexp(base)
+		if err != nil {
+			t.Fatalf("base %d: unexpected error: %s", base, err)
+		}
+		for i := 0; i < 20; i++ {
+			str := strconv.FormatInt(int64(i), base)
+			if a, b := expr.Matches(i), re.MatchString(str); a != b {
+				t.Errorf("base %d: %q: expected match == %v, got %v", base, str, a, b)
+			}
+		}
+	}
+	re, err := expr.ToRegexp(10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, str := range []string{"", "011", "1,3", "110", " 1"} {
+		if re.MatchString(str) {
+			t.Errorf("expected no match for %q", str)
+		}
+	}
+
+	for _, input := range []string{"1,3-", "*", "0-10000"} {
+		expr, err := ParseExpression(input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if _, err := expr.ToRegexp(10); err == nil {
+			t.Errorf("%q: expected error, got nil", input)
+		}
+	}
+	if _, err := expr.ToRegexp(1); err == nil {
+		t.Errorf("expected error for invalid base, got nil")
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect string
+	}{
+		{a: "1-10", b: "3-5", expect: "1-2,6-10"},
+		{a: "1-10", b: "0-20", expect: ""},
+		{a: "1-10", b: "1-10", expect: ""},
+		{a: "1-10", b: "", expect: "1-10"},
+		{a: "", b: "1-10", expect: ""},
+		{a: "1-", b: "5-7", expect: "1-4,8-"},
+		{a: "1-", b: "5-", expect: "1-4"},
+		{a: "1-10", b: "*", expect: ""},
+		{a: "*", b: "*", expect: ""},
+		{a: "3,1-2", b: "7-", expect: "1-3"},
+	}
+	opts := ParseOptions{Delimiter: ",", AllowEmptyExpression: true}
+	for _, test := range cases {
+		a, err := ParseExpressionWithOptions(test.a, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		b, err := ParseExpressionWithOptions(test.b, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		got := Subtract(a, b)
+		if got.String() != test.expect {
+			t.Errorf("Subtract(%q, %q): expected %q, got %q", test.a, test.b, test.expect, got.String())
+		}
+		if m := a.Subtract(b).String(); m != test.expect {
+			t.Errorf("%q.Subtract(%q): expected %q, got %q", test.a, test.b, test.expect, m)
+		}
+		for v := -5; v < 30; v++ {
+			if want := a.Matches(v) && !b.Matches(v); got.Matches(v) != want {
+				t.Errorf("Subtract(%q, %q).Matches(%d): expected %v, got %v", test.a, test.b, v, want, got.Matches(v))
+			}
+		}
+	}
+
+	// the negative values matched by "*" are left out, as they can not be
+	// written in an expression
+	all, err := ParseExpression("*")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	b, err := ParseExpression("5-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	got := all.Subtract(b)
+	if got.String() != "0-4" {
+		t.Errorf("'*' - '5-': expected %q, got %q", "0-4", got.String())
+	}
+	for _, v := range []int{0, 4} {
+		if !got.Matches(v) {
+			t.Errorf("'*' - '5-': expected %d to match", v)
+		}
+	}
+	for _, v := range []int{math.MinInt, -1, 5, math.MaxInt} {
+		if got.Matches(v) {
+			t.Errorf("'*' - '5-': expected %d not to match", v)
+		}
+	}
+}
+
+func TestSetOperationsWildcardRoundTrip(t *testing.T) {
+	opts := ParseOptions{Delimiter: ",", AllowEmptyExpression: true}
+	parse := func(s string) Expression {
+		e, err := ParseExpressionWithOptions(s, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		return e
+	}
+	all, some := parse("*"), parse("1-5,10-")
+	cases := []struct {
+		name   string
+		got    Expression
+		expect string
+	}{
+		{name: "Union", got: Union(all, some), expect: "*"},
+		{name: "Subtract", got: Subtract(all, some), expect: "0,6-9"},
+		{name: "Subtract-reverse", got: Subtract(some, all), expect: ""},
+		{name: "Intersect", got: Intersect(all, some), expect: "1-5,10-"},
+		{name: "Intersect-all", got: Intersect(all, all), expect: "*"},
+		{name: "SymmetricDiff", got: SymmetricDiff(all, some), expect: "0,6-9"},
+		{name: "Difference", got: Difference(all, some, parse("0")), expect: "6-9"},
+		{name: "Complement", got: Complement(all, 0, 100), expect: ""},
+		{name: "Complement-some", got: Complement(some, 0, 100), expect: "0,6-9"},
+	}
+	for _, test := range cases {
+		s := test.got.String()
+		if s != test.expect {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expect, s)
+		}
+		again, err := ParseExpressionWithOptions(s, opts)
+		if err != nil {
+			t.Errorf("%s: String() %q failed to parse: %s", test.name, s, err)
+			continue
+		}
+		for v := 0; v < 20; v++ {
+			if again.Matches(v) != test.got.Matches(v) {
+				t.Errorf("%s: reparsed %q differs at %d", test.name, s, v)
+			}
+		}
+	}
+}
+
+func TestSubExpressionSort(t *testing.T) {
+	byStartDesc := func(a, b SubExpressionInfo) bool {
+		return a.Start > b.Start
+	}
+	byWidth := func(a, b SubExpressionInfo) bool {
+		if a.Unbounded || a.MatchAll {
+			return false
+		}
+		return b.Unbounded || b.MatchAll || a.End-a.Start < b.End-b.Start
+	}
+	cases := []struct {
+		input     string
+		sort      SortFunc
+		normalize bool
+		expect    string
+	}{
+		{input: "3-5,1,7-", sort: nil, expect: "3-5,1,7-"},
+		{input: "3-5,1,7-", sort: byStartDesc, expect: "7-,3-5,1"},
+		{input: "3-5,1,7-,10-11", sort: byWidth, expect: "1,10-11,3-5,7-"},
+		{input: "3-5,1,7-,4-6", sort: byStartDesc, normalize: true, expect: "3-,1"},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.SubExpressionSort = test.sort
+		opts.PostProcessNormalize = test.normalize
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.String(); got != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expect, got)
+		}
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect string
+	}{
+		{a: "1-10", b: "3-5", expect: "3-5"},
+		{a: "1-10", b: "5-20", expect: "5-10"},
+		{a: "1-3", b: "5-7", expect: ""},
+		{a: "1-10", b: "", expect: ""},
+		{a: "1-3,7-", b: "2-8", expect: "2-3,7-8"},
+		{a: "1-", b: "5-", expect: "5-"},
+		{a: "1-5", b: "*", expect: "1-5"},
+		{a: "*", b: "*", expect: "*"},
+	}
+	opts := ParseOptions{Delimiter: ",", AllowEmptyExpression: true}
+	for _, test := range cases {
+		a, err := ParseExpressionWithOptions(test.a, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		b, err := ParseExpressionWithOptions(test.b, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := Intersect(a, b).String(); got != test.expect {
+			t.Errorf("Intersect(%q, %q): expected %q, got %q", test.a, test.b, test.expect, got)
+		}
+		if got := b.Intersect(a).String(); got != test.expect {
+			t.Errorf("%q.Intersect(%q): expected %q, got %q", test.b, test.a, test.expect, got)
+		}
+	}
+}
+
+func TestParseExpressionList(t *testing.T) {
+	valid := []string{"1,3-5", "7-", "*"}
+	exprs, err := ParseExpressionList(valid, DefaultParseOptions())
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if len(exprs) != len(valid) {
+		t.Fatalf("expected %d expressions, got %d", len(valid), len(exprs))
+	}
+	for i, expr := range exprs {
+		if expr.String() != valid[i] {
+			t.Errorf("expected %q, got %q", valid[i], expr.String())
+		}
+	}
+
+	invalid := []string{"1,3-5", "x", "7-", "5-3"}
+	exprs, err = ParseExpressionList(invalid, DefaultParseOptions())
+	if err == nil || exprs != nil {
+		t.Fatalf("expected error and no expressions, got %v, %v", exprs, err)
+	}
+}
+
+func TestParseExpressionListAll(t *testing.T) {
+	inputs := []string{"1,3-5", "x", "7-", "5-3"}
+	exprs, errs := ParseExpressionListAll(inputs, DefaultParseOptions())
+	if len(exprs) != len(inputs) {
+		t.Fatalf("expected %d expressions, got %d", len(inputs), len(exprs))
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(errs), errs)
+	}
+	if exprs[0].String() != "1,3-5" || exprs[2].String() != "7-" {
+		t.Errorf("unexpected expressions: %v", exprs)
+	}
+	if !exprs[1].MatchesNone() || !exprs[3].MatchesNone() {
+		t.Errorf("expected empty expressions for invalid inputs: %v", exprs)
+	}
+
+	_, errs = ParseExpressionListAll([]string{"1", "2"}, DefaultParseOptions())
+	if len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+}
+
+func TestAllowPrefixPlus(t *testing.T) {
+	cases := []struct {
+		input     string
+		allow     bool
+		shouldErr bool
+		expect    string
+	}{
+		{input: "+5", allow: false, shouldErr: true},
+		{input: "1-+5", allow: false, shouldErr: true},
+		{input: "+5", allow: true, expect: "5"},
+		{input: "+1 - +5, +7-", allow: true, expect: "1-5,7-"},
+		{input: "++5", allow: true, shouldErr: true},
+		{input: "+-5", allow: true, shouldErr: true},
+		{input: "+", allow: true, shouldErr: true},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.AllowPrefixPlus = test.allow
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got nil", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: unexpected error from parser: %s", test.input, err)
+		}
+		if got := expr.String(); got != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expect, got)
+		}
+	}
+
+	// the prefix is valid syntax, just prohibited by the options
+	opts := DefaultParseOptions()
+	opts.ErrorFactory = func(kind ErrorKind, token string, pos int) error {
+		return testParseError{kind, token, pos}
+	}
+	_, err := ParseExpressionWithOptions("1-+5", opts)
+	var perr testParseError
+	if !errors.As(err, &perr) || perr != (testParseError{ErrorKindProhibited, "1-+5", 0}) {
+		t.Errorf("expected ErrorKindProhibited for \"1-+5\" at 0, got %#v", err)
+	}
+}
+
+func TestComplement(t *testing.T) {
+	cases := []struct {
+		input  string
+		lo, hi int
+		expect string
+	}{
+		{input: "1-5", lo: 1, hi: 10, expect: "6-10"},
+		{input: "3-5,8", lo: 1, hi: 10, expect: "1-2,6-7,9-10"},
+		{input: "3-", lo: 0, hi: 100, expect: "0-2"},
+		{input: "*", lo: 0, hi: 100, expect: ""},
+		{input: "50-60", lo: 1, hi: 10, expect: "1-10"},
+		{input: "1-5", lo: 10, hi: 1, expect: ""},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := Complement(expr, test.lo, test.hi).String(); got != test.expect {
+			t.Errorf("Complement(%q, %d, %d): expected %q, got %q", test.input, test.lo, test.hi, test.expect, got)
+		}
+		if got := expr.Normalize().Invert(test.lo, test.hi).String(); got != test.expect {
+			t.Errorf("%q.Invert(%d, %d): expected %q, got %q", test.input, test.lo, test.hi, test.expect, got)
+		}
+	}
+}
+
+func TestBoundedPart(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{input: "1,3-5,7-", expect: "1,3-5"},
+		{input: "7-,3-5,*,1", expect: "3-5,1"},
+		{input: "7-,*", expect: ""},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.Delimiter = ";"
+		expr, err := ParseExpressionWithOptions(strings.ReplaceAll(test.input, ",", ";"), opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		got := expr.BoundedPart()
+		if s := strings.ReplaceAll(got.String(), ";", ","); s != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expect, s)
+		}
+		if !reflect.DeepEqual(got.opts, opts) {
+			t.Errorf("%q: options not retained", test.input)
+		}
+	}
+}
+
+func TestRejectWildcardStar(t *testing.T) {
+	cases := []struct {
+		input     string
+		reject    bool
+		shouldErr bool
+	}{
+		{input: "*", reject: false},
+		{input: "1,*", reject: false},
+		{input: "*", reject: true, shouldErr: true},
+		{input: "1, * ,3", reject: true, shouldErr: true},
+		{input: "1,3", reject: true},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.RejectWildcardStar = test.reject
+		_, err := ParseExpressionWithOptions(test.input, opts)
+		if test.shouldErr && err == nil {
+			t.Errorf("%q: expected error, got nil", test.input)
+		}
+		if !test.shouldErr && err != nil {
+			t.Errorf("%q: unexpected error: %s", test.input, err)
+		}
+	}
+}
+
+func TestRejectLeadingZeros(t *testing.T) {
+	cases := []struct {
+		input      string
+		reject     bool
+		base       int
+		shouldErr  bool
+		expectText string
+	}{
+		{input: "007-012", reject: false, expectText: "7-12"},
+		{input: "007-012", reject: true, shouldErr: true},
+		{input: "1,05", reject: true, shouldErr: true},
+		{input: "+05", reject: true, shouldErr: true},
+		{input: "0,0-5,10", reject: true, expectText: "0,0-5,10"},
+		{input: "0ff", reject: true, base: 16, shouldErr: true},
+		{input: "017", reject: true, base: IntBaseAuto, expectText: "017"},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.RejectLeadingZeros = test.reject
+		opts.AllowPrefixPlus = true
+		opts.IntBase = test.base
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got nil", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.input, err)
+			continue
+		}
+		if got := expr.String(); got != test.expectText {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expectText, got)
+		}
+	}
+
+	// leading zeros are valid syntax, just prohibited by the options
+	opts := DefaultParseOptions()
+	opts.RejectLeadingZeros = true
+	opts.ErrorFactory = func(kind ErrorKind, token string, pos int) error {
+		return testParseError{kind, token, pos}
+	}
+	_, err := ParseExpressionWithOptions("1,3-05", opts)
+	var perr testParseError
+	if !errors.As(err, &perr) || perr != (testParseError{ErrorKindProhibited, "3-05", 2}) {
+		t.Errorf("expected ErrorKindProhibited for \"3-05\" at 2, got %#v", err)
+	}
+}
+
+func TestMatchesSliceIndices(t *testing.T) {
+	cases := []struct {
+		input  string
+		s      []int
+		expect []int
+	}{
+		{input: "1,3,5", s: []int{10, 20, 30, 40, 50}, expect: []int{0, 2, 4}},
+		{input: "1,3,5-", s: []int{10, 20, 30, 40, 50, 60}, expect: []int{0, 2, 4, 5}},
+		{input: "0,7-", s: []int{10, 20, 30}, expect: nil},
+		{input: "*", s: []int{5, 5}, expect: []int{0, 1}},
+		{input: "1-3", s: nil, expect: nil},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.MatchesSliceIndices(test.s); !reflect.DeepEqual(test.expect, got) {
+			t.Errorf("%q, %v: expected %v, got %v", test.input, test.s, test.expect, got)
+		}
+	}
+}
+
+func TestUnboundedPart(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{input: "1,3-5,7-", expect: "7-"},
+		{input: "7-,3-5,*,1", expect: "7-,*"},
+		{input: "1,3-5", expect: ""},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		got := expr.UnboundedPart()
+		if got.String() != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expect, got.String())
+		}
+		if a, b := test.expect == "", got.MatchesNone(); a != b {
+			t.Errorf("%q: expected MatchesNone() == %v, got %v", test.input, a, b)
+		}
+		if merged := Union(got, expr.BoundedPart()); merged.Key() != expr.Key() {
+			t.Errorf("%q: bounded and unbounded parts do not add up: %q", test.input, merged)
+		}
+	}
+}
+
+func TestSubExpressionTransformer(t *testing.T) {
+	// convert 1-based input into 0-based values, and reject wildcards
+	toZeroBased := func(info SubExpressionInfo) (SubExpressionInfo, error) {
+		if info.MatchAll {
+			return info, fmt.Errorf("wildcard not allowed here")
+		}
+		if info.Start < 1 {
+			return info, fmt.Errorf("positions start from 1")
+		}
+		info.Start--
+		if !info.Unbounded {
+			info.End--
+		}
+		return info, nil
+	}
+	invalid := func(info SubExpressionInfo) (SubExpressionInfo, error) {
+		info.End = info.Start - 1
+		return info, nil
+	}
+	cases := []struct {
+		input     string
+		fn        func(SubExpressionInfo) (SubExpressionInfo, error)
+		shouldErr bool
+		expect    string
+	}{
+		{input: "1,3-5,7-", fn: toZeroBased, expect: "0,2-4,6-"},
+		{input: "1,*", fn: toZeroBased, shouldErr: true},
+		{input: "0-3", fn: toZeroBased, shouldErr: true},
+		{input: "3-5", fn: invalid, shouldErr: true},
+	}
+	for _, test := range cases {
+		opts := DefaultParseOptions()
+		opts.SubExpressionTransformer = test.fn
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got nil", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: unexpected error from parser: %s", test.input, err)
+		}
+		if got := expr.String(); got != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expect, got)
+		}
+	}
+}
+
+func TestNegation(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.AllowNegation = true
+
+	cases := []struct {
+		input      string
+		matches    []int
+		notMatches []int
+		normalized string
+	}{
+		{input: "1-10,^5", matches: []int{1, 4, 6, 10}, notMatches: []int{0, 5, 11}, normalized: "1-4,6-10"},
+		{input: "^5,1-10", matches: []int{1, 4, 6, 10}, notMatches: []int{0, 5, 11}, normalized: "1-4,6-10"},
+		{input: "1-,^3-4,^8-", matches: []int{1, 2, 5, 7}, notMatches: []int{3, 4, 8, 100}, normalized: "1-2,5-7"},
+		{input: "*,^5", matches: []int{0, 4, 6, 100}, notMatches: []int{5}, normalized: "0-4,6-"},
+		{input: "*,^0", matches: []int{1, 100}, notMatches: []int{0}, normalized: "1-"},
+		{input: "1-3, ^ 2 ,^*", notMatches: []int{1, 2, 3}, normalized: ""},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("%q: unexpected error from parser: %s", test.input, err)
+		}
+		norm := expr.Normalize()
+		for _, v := range test.matches {
+			if !expr.Matches(v) || !norm.Matches(v) {
+				t.Errorf("%q: expected %d to match", test.input, v)
+			}
+		}
+		for _, v := range test.notMatches {
+			if expr.Matches(v) || norm.Matches(v) {
+				t.Errorf("%q: expected %d not to match", test.input, v)
+			}
+		}
+		if expr.MatchesAll() {
+			t.Errorf("%q: expected MatchesAll() == false", test.input)
+		}
+		if norm.String() != test.normalized {
+			t.Errorf("%q: expected normalized %q, got %q", test.input, test.normalized, norm.String())
+		}
+		if norm.Key() != test.normalized {
+			t.Errorf("%q: expected key %q, got %q", test.input, test.normalized, norm.Key())
+		}
+		normOpts := opts
+		normOpts.AllowEmptyExpression = true
+		if _, err := ParseExpressionWithOptions(norm.String(), normOpts); err != nil {
+			t.Errorf("%q: normalized %q failed to parse: %s", test.input, norm.String(), err)
+		}
+		normOpts.PostProcessNormalize = true
+		if pp, err := ParseExpressionWithOptions(test.input, normOpts); err != nil || pp.String() != test.normalized {
+			t.Errorf("%q: expected post-processed %q, got %q (%v)", test.input, test.normalized, pp.String(), err)
+		}
+		again, err := ParseExpressionWithOptions(expr.String(), opts)
+		if err != nil {
+			t.Fatalf("%q: String() %q failed to parse: %s", test.input, expr.String(), err)
+		}
+		if !reflect.DeepEqual(expr.intervals, again.intervals) {
+			t.Errorf("%q: round-trip mismatch: %q", test.input, expr.String())
+		}
+	}
+
+	connected, err := ParseExpressionWithOptions("*,^0", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if !connected.IsConnected() {
+		t.Errorf("expected %q to be connected", connected.String())
+	}
+
+	expr, err := ParseExpressionWithOptions("1-10,^5,12-", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if expr.MatchesRange(5, 5) || !expr.MatchesRange(5, 6) {
+		t.Errorf("MatchesRange() does not account for negation")
+	}
+	if got := expr.BoundedPart().String(); got != "1-4,6-10" {
+		t.Errorf("expected bounded part %q, got %q", "1-4,6-10", got)
+	}
+	if s, r, h, m := expr.CountSubExpressionsOfType(); s != 0 || r != 1 || h != 1 || m != 0 {
+		t.Errorf("expected (0, 1, 1, 0), got (%d, %d, %d, %d)", s, r, h, m)
+	}
+	five, err := ParseExpression("5")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if got := Union(expr, five).String(); got != "1-10,12-" {
+		t.Errorf("expected union %q, got %q", "1-10,12-", got)
+	}
+
+	if _, err := ParseExpressionWithOptions("^5", opts); err == nil {
+		t.Errorf("expected error for expression with only negations, got nil")
+	}
+	if _, err := ParseExpression("1-10,^5"); err == nil {
+		t.Errorf("expected error when negation is not allowed, got nil")
+	}
+}
+
+func TestLargestInterval(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect Interval
+		found  bool
+	}{
+		{input: "1,3-5,10-50,60-", expect: Interval{Start: 10, End: 50}, found: true},
+		{input: "10-12,1-3,5", expect: Interval{Start: 1, End: 3}, found: true},
+		{input: "7", expect: Interval{Start: 7, End: 7}, found: true},
+		{input: "7-,*", found: false},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		got, found := expr.LargestInterval()
+		if found != test.found || got != test.expect {
+			t.Errorf("%q: expected (%v, %v), got (%v, %v)", test.input, test.expect, test.found, got, found)
+		}
+	}
+}
+
+func TestMatchesRunSlice(t *testing.T) {
+	type run = struct{ Start, Count int }
+	cases := []struct {
+		input  string
+		runs   []run
+		expect []run
+	}{
+		{
+			input:  "5-9,20-",
+			runs:   []run{{0, 5}, {3, 3}, {10, 10}, {19, 2}, {100, 1}},
+			expect: []run{{3, 3}, {19, 2}, {100, 1}},
+		},
+		{
+			input:  "5",
+			runs:   []run{{5, 0}, {5, -1}, {4, 2}},
+			expect: []run{{4, 2}},
+		},
+		{
+			input:  "1-3",
+			runs:   []run{{4, 1}},
+			expect: nil,
+		},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		got := expr.MatchesRunSlice(test.runs)
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%q: expected %v, got %v", test.input, test.expect, got)
+		}
+	}
+}
+
+func TestSmallestInterval(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect Interval
+		found  bool
+	}{
+		{input: "1-5,10-50,7,60-", expect: Interval{Start: 7, End: 7}, found: true},
+		{input: "10-12,1-3,20-40", expect: Interval{Start: 1, End: 3}, found: true},
+		{input: "7-,*", found: false},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		got, found := expr.SmallestInterval()
+		if found != test.found || got != test.expect {
+			t.Errorf("%q: expected (%v, %v), got (%v, %v)", test.input, test.expect, test.found, got, found)
+		}
+	}
+	empty, err := ParseExpressionWithOptions("", ParseOptions{Delimiter: ",", AllowEmptyExpression: true})
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if _, found := empty.SmallestInterval(); found {
+		t.Errorf("expected no interval from empty expression")
+	}
+}
+
+func TestFallbackExpression(t *testing.T) {
+	fallback, err := ParseExpression("*")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	opts := DefaultParseOptions()
+	opts.AllowEmptyExpression = true
+	opts.FallbackExpression = &fallback
+
+	for _, input := range []string{"", ",,"} {
+		expr, err := ParseExpressionWithOptions(input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if !expr.MatchesAll() {
+			t.Errorf("%q: expected fallback expression, got %q", input, expr)
+		}
+	}
+
+	expr, err := ParseExpressionWithOptions("1-3", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if expr.String() != "1-3" {
+		t.Errorf("expected fallback to be unused for non-empty input, got %q", expr)
+	}
+
+	opts.AllowEmptyExpression = false
+	if _, err := ParseExpressionWithOptions("", opts); err == nil {
+		t.Errorf("expected error on empty input when AllowEmptyExpression is false")
+	}
+}
+
+func TestMatchesWithFallback(t *testing.T) {
+	var zero Expression
+	if !zero.MatchesWithFallback(5, true) || zero.MatchesWithFallback(5, false) {
+		t.Errorf("expected zero value Expression to return the fallback")
+	}
+	expr, err := ParseExpression("1-3")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if !expr.MatchesWithFallback(2, false) || expr.MatchesWithFallback(5, true) {
+		t.Errorf("expected non-empty Expression to ignore the fallback")
+	}
+}
+
+func TestScanRoundTrip(t *testing.T) {
+	for _, input := range []string{"1-3,5,7-", "*", "0x10-0x1f,3"} {
+		opts := DefaultParseOptions()
+		opts.IntBase = IntBaseAuto
+		expr, err := ParseExpressionWithOptions(input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		s := fmt.Sprintf("%v", expr)
+		expr2 := Expression{opts: opts}
+		if _, err := fmt.Sscan(s, &expr2); err != nil {
+			t.Fatalf("%q: unexpected error from Sscan: %s", s, err)
+		}
+		if expr2.String() != expr.String() || expr2.Key() != expr.Key() {
+			t.Errorf("%q: expected %q after round-trip, got %q", input, expr, expr2)
+		}
+	}
+
+	var a, b Expression
+	if n, err := fmt.Sscanf("1-3 7-", "%v %v", &a, &b); err != nil || n != 2 {
+		t.Fatalf("unexpected result from Sscanf: %d, %v", n, err)
+	}
+	if a.String() != "1-3" || b.String() != "7-" {
+		t.Errorf("expected \"1-3\" and \"7-\", got %q and %q", a, b)
+	}
+	if _, err := fmt.Sscan("1-x", &a); err == nil {
+		t.Errorf("expected error from Sscan on invalid input")
+	}
+}
+
+func TestSize(t *testing.T) {
+	cases := []struct {
+		input   string
+		count   int
+		bounded bool
+	}{
+		{input: "1,3-5,3-4", count: 3, bounded: true},
+		{input: "1,7-", count: 2, bounded: false},
+		{input: "*", count: 1, bounded: false},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		count, bounded := expr.Size()
+		if count != test.count || bounded != test.bounded {
+			t.Errorf("%q: expected (%d, %v), got (%d, %v)", test.input, test.count, test.bounded, count, bounded)
+		}
+	}
+	if count, bounded := (Expression{}).Size(); count != 0 || !bounded {
+		t.Errorf("expected (0, true) for empty Expression, got (%d, %v)", count, bounded)
+	}
+}
+
+func TestSubsetOf(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect bool
+	}{
+		{a: "2-4,6", b: "1-6", expect: true},
+		{a: "1-6", b: "2-4,6", expect: false},
+		{a: "1-3,4-5", b: "1-5", expect: true},
+		{a: "10-", b: "5-", expect: true},
+		{a: "5-", b: "1-100", expect: false},
+		{a: "*", b: "0-", expect: true},
+		{a: "*", b: "1-", expect: false},
+		{a: "1-10,^5", b: "1-4,6-10", expect: true},
+	}
+	opts := DefaultParseOptions()
+	opts.AllowNegation = true
+	for _, test := range cases {
+		a, err := ParseExpressionWithOptions(test.a, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		b, err := ParseExpressionWithOptions(test.b, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := SubsetOf(a, b); got != test.expect {
+			t.Errorf("%q ⊆ %q: expected %v, got %v", test.a, test.b, test.expect, got)
+		}
+		if got := a.SubsetOf(b); got != test.expect {
+			t.Errorf("%q.SubsetOf(%q): expected %v, got %v", test.a, test.b, test.expect, got)
+		}
+	}
+}
+
+func TestRecoverFromPanic(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.SubExpressionTransformer = func(info SubExpressionInfo) (SubExpressionInfo, error) {
+		if info.Start == 13 {
+			panic("unlucky")
+		}
+		return info, nil
+	}
+	opts.RecoverFromPanic = true
+
+	expr, err := ParseExpressionWithOptions("1-3,13", opts)
+	if err == nil || !strings.Contains(err.Error(), "unlucky") {
+		t.Errorf("expected error describing the panic, got %v", err)
+	}
+	if len(expr.intervals) != 0 {
+		t.Errorf("expected empty Expression on panic, got %q", expr)
+	}
+	if expr, err := ParseExpressionWithOptions("1-3", opts); err != nil || expr.String() != "1-3" {
+		t.Errorf("expected hook without panic to parse normally, got %q, %v", expr, err)
+	}
+
+	// the other hooks are covered as well
+	hooks := []struct {
+		name  string
+		input string
+		set   func(*ParseOptions)
+	}{
+		{name: "ErrorFactory", input: "1,x", set: func(o *ParseOptions) {
+			o.ErrorFactory = func(ErrorKind, string, int) error { panic("unlucky") }
+		}},
+		{name: "WarnHandler", input: "2,2", set: func(o *ParseOptions) {
+			o.WarnHandler = func(string) { panic("unlucky") }
+		}},
+		{name: "SubExpressionSort", input: "2,1", set: func(o *ParseOptions) {
+			o.SubExpressionSort = func(a, b SubExpressionInfo) bool { panic("unlucky") }
+		}},
+	}
+	for _, test := range hooks {
+		hookOpts := DefaultParseOptions()
+		hookOpts.RecoverFromPanic = true
+		test.set(&hookOpts)
+		if _, err := ParseExpressionWithOptions(test.input, hookOpts); err == nil || !strings.Contains(err.Error(), "unlucky") {
+			t.Errorf("%s: expected error describing the panic, got %v", test.name, err)
+		}
+	}
+
+	opts.RecoverFromPanic = false
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected panic to propagate when RecoverFromPanic is false")
+		}
+	}()
+	_, _ = ParseExpressionWithOptions("13", opts)
+}
+
+func TestZeroBased(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.ZeroBased = true
+	expr, err := ParseExpressionWithOptions("1-3,5,8-", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	for val, expect := range map[int]bool{0: true, 2: true, 3: false, 4: true, 5: false, 6: false, 7: true, 100: true} {
+		if got := expr.Matches(val); got != expect {
+			t.Errorf("Matches(%d): expected %v, got %v", val, expect, got)
+		}
+	}
+	if s := expr.String(); s != "1-3,5,8-" {
+		t.Errorf("expected String() to restore 1-based values, got %q", s)
+	}
+
+	opts.PostProcessNormalize = true
+	expr, err = ParseExpressionWithOptions("1-3,4,*", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if s := expr.String(); s != "*" {
+		t.Errorf("expected \"*\", got %q", s)
+	}
+	expr, err = ParseExpressionWithOptions("1-3,4", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if s := expr.String(); s != "1-4" {
+		t.Errorf("expected \"1-4\", got %q", s)
+	}
+
+	if _, err := ParseExpressionWithOptions("0-3", opts); err == nil {
+		t.Errorf("expected error on value 0 in 1-based input")
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	expr, err := ParseExpression("1-3,5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	const expect = `"1-3,5,7-"`
+	if b, err := expr.ToJSON(); err != nil || string(b) != expect {
+		t.Errorf("ToJSON: expected %s, got %s (%v)", expect, b, err)
+	}
+	if s, err := expr.ToJSONString(); err != nil || s != expect {
+		t.Errorf("ToJSONString: expected %s, got %s (%v)", expect, s, err)
+	}
+	b, err := json.Marshal(struct{ Pages Expression }{expr})
+	if err != nil || string(b) != `{"Pages":`+expect+`}` {
+		t.Errorf("json.Marshal: unexpected result %s (%v)", b, err)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	cases := []struct {
+		input  string
+		delta  int
+		expect string
+	}{
+		{input: "1-3,5,7-", delta: 10, expect: "11-13,15,17-"},
+		{input: "11-13,15,17-", delta: -10, expect: "1-3,5,7-"},
+		{input: "1-3,5,7-", delta: -4, expect: "1,3-"},
+		{input: "2-6,9", delta: -4, expect: "0-2,5"},
+		{input: "1-3,*", delta: -2, expect: "0-1,*"},
+		{input: "0-,5", delta: math.MaxInt, expect: "9223372036854775807-"},
+		{input: "3-5", delta: 0, expect: "3-5"},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if strconv.IntSize != 64 && test.delta == math.MaxInt {
+			continue
+		}
+		if got := expr.Translate(test.delta).String(); got != test.expect {
+			t.Errorf("%q.Translate(%d): expected %q, got %q", test.input, test.delta, test.expect, got)
+		}
+	}
+
+	opts := DefaultParseOptions()
+	opts.IntBase = IntBaseAuto
+	expr, err := ParseExpressionWithOptions("0x10-0x1f", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if got := expr.Translate(16).String(); got != "0x20-0x2f" {
+		t.Errorf("expected notation to be retained, got %q", got)
+	}
+}
+
+func TestScale(t *testing.T) {
+	cases := []struct {
+		input  string
+		factor int
+		expect string
+	}{
+		{input: "2-3,5-,7", factor: 10, expect: "20-30,50-,70"},
+		{input: "1-3,*", factor: 1, expect: "1-3,*"},
+		{input: "0-1", factor: 80, expect: "0-80"},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		scaled, err := expr.Scale(test.factor)
+		if err != nil {
+			t.Fatalf("%q: unexpected error from Scale: %s", test.input, err)
+		}
+		if got := scaled.String(); got != test.expect {
+			t.Errorf("%q.Scale(%d): expected %q, got %q", test.input, test.factor, test.expect, got)
+		}
+	}
+
+	expr, err := ParseExpression("1-3")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if scaled, err := expr.Scale(10); err != nil || !scaled.Matches(25) {
+		t.Errorf("expected scaled range to match values between multiples, got %q, %v", scaled, err)
+	}
+	for _, factor := range []int{0, -1, math.MaxInt} {
+		if _, err := expr.Scale(factor); err == nil {
+			t.Errorf("Scale(%d): expected error", factor)
+		}
+	}
+}
+
+func TestLocale(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.Locale = "de-DE"
+	expr, err := ParseExpressionWithOptions("1.000-2.000,5,10.000-", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	for val, expect := range map[int]bool{999: false, 1000: true, 2000: true, 2001: false, 5: true, 10000: true} {
+		if got := expr.Matches(val); got != expect {
+			t.Errorf("Matches(%d): expected %v, got %v", val, expect, got)
+		}
+	}
+	if s := expr.String(); s != "1000-2000,5,10000-" {
+		t.Errorf("expected values without grouping, got %q", s)
+	}
+
+	opts.Delimiter = ";"
+	opts.Locale = "en_US"
+	if expr, err := ParseExpressionWithOptions("1,000-2,000;3", opts); err != nil || expr.String() != "1000-2000;3" {
+		t.Errorf("expected \"1000-2000;3\", got %q, %v", expr, err)
+	}
+
+	invalid := []struct {
+		input, delimiter, locale string
+	}{
+		{input: "1.00", delimiter: ",", locale: "de"},
+		{input: "1.0000", delimiter: ",", locale: "de"},
+		{input: ".100", delimiter: ",", locale: "de"},
+		{input: "1,000", delimiter: ",", locale: "en"},
+		{input: "1", delimiter: ",", locale: "xx"},
+		{input: "1.000", delimiter: ",", locale: ""},
+	}
+	for _, test := range invalid {
+		opts.Delimiter, opts.Locale = test.delimiter, test.locale
+		if _, err := ParseExpressionWithOptions(test.input, opts); err == nil {
+			t.Errorf("%q with locale %q: expected error", test.input, test.locale)
+		}
+	}
+}
+
+func TestMatches16(t *testing.T) {
+	expr, err := ParseExpression("80,443,1024-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	for val, expect := range map[int16]bool{80: true, 81: false, 443: true, 1023: false, math.MaxInt16: true, -1: false, math.MinInt16: false} {
+		if got := expr.Matches16(val); got != expect {
+			t.Errorf("Matches16(%d): expected %v, got %v", val, expect, got)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		input  string
+		maxVal int
+		expect string
+	}{
+		{input: "1-3,7-", maxVal: 10, expect: "1-3,7-10"},
+		{input: "1-3,7-", maxVal: 7, expect: "1-3,7"},
+		{input: "1-3,7-", maxVal: 2, expect: "1-2"},
+		{input: "5-20,12", maxVal: 10, expect: "5-10"},
+		{input: "*", maxVal: 4, expect: "0-4"},
+		{input: "*", maxVal: -1, expect: ""},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		truncated := expr.Truncate(test.maxVal)
+		if got := truncated.String(); got != test.expect {
+			t.Errorf("%q.Truncate(%d): expected %q, got %q", test.input, test.maxVal, test.expect, got)
+		}
+		if _, bounded := truncated.Size(); !bounded {
+			t.Errorf("%q.Truncate(%d): expected bounded result", test.input, test.maxVal)
+		}
+	}
+}
+
+func TestMatches8(t *testing.T) {
+	expr, err := ParseExpression("0-9,100-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	for val, expect := range map[int8]bool{0: true, 9: true, 10: false, 100: true, math.MaxInt8: true, -1: false, math.MinInt8: false} {
+		if got := expr.Matches8(val); got != expect {
+			t.Errorf("Matches8(%d): expected %v, got %v", val, expect, got)
+		}
+	}
+}
+
+func TestSymmetricDiff(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect string
+	}{
+		{a: "1-5", b: "4-8", expect: "1-3,6-8"},
+		{a: "1-5,10-", b: "3", expect: "1-2,4-5,10-"},
+		{a: "1-5", b: "1-5", expect: ""},
+		{a: "*", b: "*", expect: ""},
+		{a: "5-", b: "10-", expect: "5-9"},
+	}
+	for _, test := range cases {
+		a, err := ParseExpression(test.a)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		b, err := ParseExpression(test.b)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		got := a.SymmetricDiff(b)
+		if got.String() != test.expect {
+			t.Errorf("%q ⊕ %q: expected %q, got %q", test.a, test.b, test.expect, got)
+		}
+		if alt := Union(Subtract(a, b), Subtract(b, a)); got.Key() != alt.Key() {
+			t.Errorf("%q ⊕ %q: expected %q, got %q", test.a, test.b, alt, got)
+		}
+	}
+}
+
+func TestRejectSingletonAsRange(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.RejectSingletonAsRange = true
+	_, err := ParseExpressionWithOptions("1,5-5,7-", opts)
+	if err == nil {
+		t.Fatalf("expected error on single value range")
+	}
+	if !strings.Contains(err.Error(), `"5"`) {
+		t.Errorf("expected error to suggest the canonical form, got %q", err)
+	}
+	for _, input := range []string{"1,5,7-", "5-6"} {
+		if _, err := ParseExpressionWithOptions(input, opts); err != nil {
+			t.Errorf("%q: unexpected error: %s", input, err)
+		}
+	}
+	opts.RejectSingletonAsRange = false
+	if _, err := ParseExpressionWithOptions("5-5", opts); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestDistanceTo(t *testing.T) {
+	cases := []struct {
+		input  string
+		val    int
+		expect int
+	}{
+		{input: "1-3,10-12,20-", val: 2, expect: 0},
+		{input: "1-3,10-12,20-", val: 5, expect: 2},
+		{input: "1-3,10-12,20-", val: 8, expect: 2},
+		{input: "1-3,10-12,20-", val: 15, expect: 3},
+		{input: "1-3,10-12,20-", val: 1000, expect: 0},
+		{input: "5", val: -5, expect: 10},
+		{input: "*", val: math.MinInt, expect: 0},
+		{input: "0", val: math.MinInt, expect: math.MaxInt},
+		{input: "0", val: math.MaxInt, expect: math.MaxInt},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.DistanceTo(test.val); got != test.expect {
+			t.Errorf("%q.DistanceTo(%d): expected %d, got %d", test.input, test.val, test.expect, got)
+		}
+	}
+	if got := (Expression{}).DistanceTo(5); got != -1 {
+		t.Errorf("expected -1 for empty Expression, got %d", got)
+	}
+}
+
+func TestMatchesSegment(t *testing.T) {
+	expr, err := ParseExpression("1,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	cases := []struct {
+		lo, hi int
+		expect bool
+	}{
+		{lo: 2, hi: 6, expect: false},
+		{lo: 2, hi: 7, expect: true},
+		{lo: 0, hi: 1, expect: true},
+		{lo: 5, hi: 3, expect: false},
+		{lo: math.MinInt, hi: math.MaxInt, expect: true},
+	}
+	for _, test := range cases {
+		if got := expr.MatchesSegment(test.lo, test.hi); got != test.expect {
+			t.Errorf("MatchesSegment(%d, %d): expected %v, got %v", test.lo, test.hi, test.expect, got)
+		}
+	}
+}
+
+func TestAllowNested(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.AllowNested = true
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{input: "(1-3),(5-7)", expect: "1-3,5-7"},
+		{input: "(1,3-4),9-", expect: "1,3-4,9-"},
+		{input: "((1),(2,(3-4)))", expect: "1,2,3-4"},
+		{input: " ( 1 , 2 ) ,5", expect: "1,2,5"},
+		{input: "1,(),2", expect: "1,2"},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("%q: unexpected error from parser: %s", test.input, err)
+		}
+		if got := expr.String(); got != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expect, got)
+		}
+	}
+
+	for _, input := range []string{"(1-3", "1-3)", "(1)(2)", "(1)2", "((1)"} {
+		if _, err := ParseExpressionWithOptions(input, opts); err == nil {
+			t.Errorf("%q: expected error", input)
+		}
+	}
+	opts.AllowNested = false
+	if _, err := ParseExpressionWithOptions("(1-3)", opts); err == nil {
+		t.Errorf("expected error on groups when AllowNested is false")
+	}
+}
+
+func TestParseOptionsMerge(t *testing.T) {
+	base := DefaultParseOptions()
+	got := base.Merge(ParseOptions{Delimiter: ";", IntBase: 16, PostProcessNormalize: true})
+	expect := base
+	expect.Delimiter, expect.IntBase, expect.PostProcessNormalize = ";", 16, true
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if got := base.Merge(ParseOptions{}); !reflect.DeepEqual(got, base) {
+		t.Errorf("expected empty override to change nothing, got %v", got)
+	}
+	normalizing := ParseOptions{Delimiter: ",", PostProcessNormalize: true}
+	if got := normalizing.Merge(ParseOptions{PostProcessNormalize: false}); !got.PostProcessNormalize {
+		t.Errorf("expected false bool in override to be ignored")
+	}
+
+	// every field must be taken from the override when set
+	typ := reflect.TypeOf(ParseOptions{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		var override ParseOptions
+		v := reflect.ValueOf(&override).Elem().Field(i)
+		switch field.Type.Kind() {
+		case reflect.String:
+			v.SetString("x")
+		case reflect.Int:
+			v.SetInt(2)
+		case reflect.Bool:
+			v.SetBool(true)
+		case reflect.Ptr:
+			v.Set(reflect.New(field.Type.Elem()))
+		case reflect.Func:
+			v.Set(reflect.MakeFunc(field.Type, func([]reflect.Value) []reflect.Value { return nil }))
+		default:
+			t.Fatalf("field %s has unexpected kind %s", field.Name, field.Type.Kind())
+		}
+		merged := ParseOptions{}.Merge(override)
+		if reflect.ValueOf(merged).Field(i).IsZero() {
+			t.Errorf("field %s was not merged", field.Name)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{input: "1-10,3-5", expect: "1-10"},
+		{input: "3-5,1-10,12", expect: "1-10,12"},
+		{input: "1-5,4-8", expect: "1-5,4-8"},
+		{input: "1-3,4-6", expect: "1-3,4-6"},
+		{input: "5,2,5,2-3", expect: "5,2-3"},
+		{input: "8-,1-3,10,9-", expect: "8-,1-3"},
+		{input: "2,*,5-", expect: "*"},
+		{input: "1-10,^3,^2-4", expect: "1-10,^2-4"},
+	}
+	opts := DefaultParseOptions()
+	opts.AllowNegation = true
+	for _, test := range cases {
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		compact := expr.Compact()
+		if got := compact.String(); got != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expect, got)
+		}
+		if compact.Key() != expr.Key() {
+			t.Errorf("%q: Compact() changed the matched values: %q", test.input, compact)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	for val := -2; val <= 10; val++ {
+		expect := expr.Matches(val)
+		if got := expr.Contains(val); got != expect {
+			t.Errorf("Contains(%d): expected %v, got %v", val, expect, got)
+		}
+		if got := expr.ContainsPoint(val); got != expect {
+			t.Errorf("ContainsPoint(%d): expected %v, got %v", val, expect, got)
+		}
+	}
+}
+
+func TestStringWithoutDelimiter(t *testing.T) {
+	expr := Expression{intervals: []subExpression{{start: 1, count: 1}, {start: 3, count: 3}}}
+	if got := expr.String(); got != "1,3-5" {
+		t.Errorf("expected \"1,3-5\", got %q", got)
+	}
+
+	// e.g an Expression whose options were lost in deserialization
+	parsed, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	parsed.opts = ParseOptions{}
+	if got := parsed.String(); got != "1,3-5,7-" {
+		t.Errorf("expected \"1,3-5,7-\", got %q", got)
+	}
+
+	opts := DefaultParseOptions()
+	opts.Delimiter = ";"
+	expr.opts = opts
+	if got := expr.String(); got != "1;3-5" {
+		t.Errorf("expected \"1;3-5\", got %q", got)
+	}
+}
+
+// testParseError is constructed by the ErrorFactory in TestErrorFactory
+type testParseError struct {
+	kind  ErrorKind
+	token string
+	pos   int
+}
+
+func (e testParseError) Error() string {
+	return fmt.Sprintf("%d: %q at %d", e.kind, e.token, e.pos)
+}
+
+func TestErrorFactory(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.AllowNested = true
+	opts.ErrorFactory = func(kind ErrorKind, token string, pos int) error {
+		return testParseError{kind, token, pos}
+	}
+	cases := []struct {
+		input  string
+		expect testParseError
+	}{
+		{input: "1,3-x", expect: testParseError{ErrorKindInvalidValue, "3-x", 2}},
+		{input: "1, 5-3", expect: testParseError{ErrorKindInvalidRange, "5-3", 3}},
+		{input: "1-2-3", expect: testParseError{ErrorKindInvalidSyntax, "1-2-3", 0}},
+		{input: "1,(2,(3,x))", expect: testParseError{ErrorKindInvalidValue, "x", 8}},
+		{input: "", expect: testParseError{ErrorKindEmptyExpression, "", 0}},
+	}
+	for _, test := range cases {
+		_, err := ParseExpressionWithOptions(test.input, opts)
+		if got, ok := err.(testParseError); !ok || got != test.expect {
+			t.Errorf("%q: expected %+v, got %#v", test.input, test.expect, err)
+		}
+	}
+
+	opts.RejectWildcardStar = true
+	_, err := ParseExpressionWithOptions("1,*", opts)
+	if got, ok := err.(testParseError); !ok || got.kind != ErrorKindProhibited {
+		t.Errorf("expected ErrorKindProhibited, got %#v", err)
+	}
+
+	// errors in the options are not parse errors
+	opts.Delimiter = ""
+	if _, err := ParseExpressionWithOptions("1", opts); err == nil {
+		t.Errorf("expected error on empty delimiter")
+	} else if _, ok := err.(testParseError); ok {
+		t.Errorf("expected ErrorFactory not to be used for invalid options")
+	}
+}
+
+func TestMatchesCSVLine(t *testing.T) {
+	cases := []struct {
+		input  string
+		line   string
+		expect []string
+	}{
+		{input: "1,3", line: "a,b,c,d", expect: []string{"a", "c"}},
+		{input: "2-", line: `x,"hello, world",z`, expect: []string{"hello, world", "z"}},
+		{input: "5", line: "a,b", expect: nil},
+		{input: "1", line: `"unterminated`, expect: nil},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.MatchesCSVLine(test.line); !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%q, %q: expected %q, got %q", test.input, test.line, test.expect, got)
+		}
+	}
+}
+
+func TestMarshalTextAndTOML(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.Delimiter = `"`
+	expr, err := ParseExpressionWithOptions(`1-3"5"7-`, opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if b, err := expr.MarshalText(); err != nil || string(b) != `1-3"5"7-` {
+		t.Errorf("MarshalText: unexpected result %s (%v)", b, err)
+	}
+	if b, err := expr.MarshalTOML(); err != nil || string(b) != `"1-3\"5\"7-"` {
+		t.Errorf("MarshalTOML: unexpected result %s (%v)", b, err)
+	}
+}
+
+func TestMatchesCIDR(t *testing.T) {
+	expr, err := ParseExpression("10-20,300")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	cases := []struct {
+		cidr   string
+		base   net.IP
+		expect bool
+	}{
+		{cidr: "192.168.1.0/24", base: nil, expect: true},
+		{cidr: "192.168.1.0/28", base: nil, expect: true},
+		{cidr: "192.168.1.0/29", base: nil, expect: false},
+		{cidr: "192.168.1.32/27", base: net.ParseIP("192.168.1.0"), expect: false},
+		{cidr: "192.168.1.0/24", base: net.ParseIP("192.168.0.0"), expect: true},
+		{cidr: "192.168.2.0/24", base: net.ParseIP("192.168.0.0"), expect: false},
+		{cidr: "192.168.1.0/24", base: net.ParseIP("192.168.2.0"), expect: false},
+		{cidr: "2001:db8::/120", base: nil, expect: true},
+		{cidr: "2001:db8::/120", base: net.ParseIP("10.0.0.0"), expect: false},
+		{cidr: "::/0", base: nil, expect: true},
+		{cidr: "not a cidr", base: nil, expect: false},
+	}
+	for _, test := range cases {
+		if got := expr.MatchesCIDR(test.cidr, test.base); got != test.expect {
+			t.Errorf("MatchesCIDR(%q, %v): expected %v, got %v", test.cidr, test.base, test.expect, got)
+		}
+	}
+
+	unbounded, err := ParseExpression("5-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if !unbounded.MatchesCIDR("8000::/1", net.ParseIP("::")) {
+		t.Errorf("expected half-open expression to match addresses beyond math.MaxInt")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.AllowNegation = true
+	for _, input := range []string{"1,3-5,7-", "*", "1-10,^5"} {
+		expr, err := ParseExpressionWithOptions(input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if err := expr.Validate(); err != nil {
+			t.Errorf("%q: unexpected error: %s", input, err)
+		}
+		if err := expr.Normalize().Validate(); err != nil {
+			t.Errorf("%q: unexpected error after Normalize(): %s", input, err)
+		}
+	}
+	if err := (Expression{}).Validate(); err != nil {
+		t.Errorf("unexpected error for empty Expression: %s", err)
+	}
+
+	invalid := []Expression{
+		{intervals: []subExpression{{start: 1, count: -1}}},
+		{intervals: []subExpression{{start: math.MaxInt, count: 2}}},
+		{intervals: []subExpression{{matchAll: true, start: 3}}},
+		{intervals: []subExpression{{start: 1, count: 1, negate: true}}},
+	}
+	for _, expr := range invalid {
+		if err := expr.Validate(); err == nil {
+			t.Errorf("%#v: expected error", expr.intervals)
+		}
+	}
+}
+
+func TestDelimiterRegexp(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.DelimiterRegexp = regexp.MustCompile(`\s*[,;]\s*|\s+`)
+	expr, err := ParseExpressionWithOptions("1, 3-5;7 9-", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if got := expr.String(); got != "1,3-5,7,9-" {
+		t.Errorf("expected \"1,3-5,7,9-\", got %q", got)
+	}
+	again, err := ParseExpressionWithOptions(expr.String(), opts)
+	if err != nil || again.String() != expr.String() {
+		t.Errorf("expected String() to round-trip, got %q, %v", again, err)
+	}
+
+	opts.Delimiter = ""
+	if _, err := ParseExpressionWithOptions("1;2", opts); err != nil {
+		t.Errorf("unexpected error with empty Delimiter: %s", err)
+	}
+
+	for _, pattern := range []string{`-`, `\d`, `\s*`, `\*`} {
+		opts.DelimiterRegexp = regexp.MustCompile(pattern)
+		if _, err := ParseExpressionWithOptions("1", opts); err == nil {
+			t.Errorf("%q: expected error on delimiter regexp matching within subexpressions", pattern)
+		}
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	cases := []struct {
+		input      string
+		complexity int
+		normalized int
+	}{
+		{input: "1,3-5,7-", complexity: 3, normalized: 3},
+		{input: "1,2,3,4-6,5", complexity: 5, normalized: 1},
+		{input: "1,*,3", complexity: 2, normalized: 0},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.Complexity(); got != test.complexity {
+			t.Errorf("%q: expected complexity %d, got %d", test.input, test.complexity, got)
+		}
+		if got := expr.Normalize().Complexity(); got != test.normalized {
+			t.Errorf("%q: expected normalized complexity %d, got %d", test.input, test.normalized, got)
+		}
+	}
+}
+
+func TestMatchesSeq(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	values := func(yield func(int) bool) {
+		for v := 0; v < 10; v++ {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+	var got []int
+	expr.MatchesSeq(values)(func(v int) bool {
+		got = append(got, v)
+		return true
+	})
+	if expect := []int{1, 3, 4, 5, 7, 8, 9}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// stopping early must stop the input sequence as well
+	got = nil
+	expr.MatchesSeq(values)(func(v int) bool {
+		got = append(got, v)
+		return len(got) < 2
+	})
+	if expect := []int{1, 3}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestStripRedundantWildcard(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{input: "*,1-3", expect: "*"},
+		{input: "1-3,*,5-,*", expect: "*"},
+		{input: "1-3,5-", expect: "1-3,5-"},
+		{input: "3,*,^5,1", expect: "*,^5"},
+		{input: "^5,1,*", expect: "^5,*"},
+	}
+	opts := DefaultParseOptions()
+	opts.StripRedundantWildcard = true
+	opts.AllowNegation = true
+	for _, test := range cases {
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.String(); got != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expect, got)
+		}
+	}
+}
+
+func TestDifference(t *testing.T) {
+	parse := func(input string) Expression {
+		expr, err := ParseExpression(input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		return expr
+	}
+	base := parse("1-20,30-")
+	cases := []struct {
+		exclusions []string
+		expect     string
+	}{
+		{exclusions: nil, expect: "1-20,30-"},
+		{exclusions: []string{"5-10"}, expect: "1-4,11-20,30-"},
+		{exclusions: []string{"5-10", "15", "40-"}, expect: "1-4,11-14,16-20,30-39"},
+		{exclusions: []string{"*", "3"}, expect: ""},
+	}
+	for _, test := range cases {
+		var exclusions []Expression
+		for _, ex := range test.exclusions {
+			exclusions = append(exclusions, parse(ex))
+		}
+		if got := Difference(base, exclusions...).String(); got != test.expect {
+			t.Errorf("%q \\ %q: expected %q, got %q", base, test.exclusions, test.expect, got)
+		}
+	}
+}
+
+func TestIndexOf(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.AllowNegation = true
+	cases := []struct {
+		input  string
+		val    int
+		lo     int
+		expect int
+		ok     bool
+	}{
+		{input: "1,3-5,7-", val: 1, lo: 0, expect: 0, ok: true},
+		{input: "1,3-5,7-", val: 5, lo: 0, expect: 3, ok: true},
+		{input: "1,3-5,7-", val: 10, lo: 0, expect: 7, ok: true},
+		{input: "1,3-5,7-", val: 10, lo: 4, expect: 5, ok: true},
+		{input: "1,3-5,7-", val: 6, lo: 0, expect: 0, ok: false},
+		{input: "1,3-5,7-", val: 3, lo: 4, expect: 0, ok: false},
+		{input: "5-1000,^10-19", val: 100, lo: 0, expect: 85, ok: true},
+		{input: "*", val: 0, lo: -10, expect: 10, ok: true},
+		{input: "*", val: math.MaxInt, lo: math.MinInt, expect: math.MaxInt, ok: true},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		got, ok := expr.IndexOf(test.val, test.lo)
+		if got != test.expect || ok != test.ok {
+			t.Errorf("%q.IndexOf(%d, %d): expected (%d, %v), got (%d, %v)", test.input, test.val, test.lo, test.expect, test.ok, got, ok)
+		}
+	}
+}
+
+func TestRandom(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.AllowNegation = true
+	expr, err := ParseExpressionWithOptions("1,3-5,7-,^8", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	seen := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		v, err := expr.Random(0, 9)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		seen[v]++
+	}
+	expect := []int{1, 3, 4, 5, 7, 9}
+	if len(seen) != len(expect) {
+		t.Errorf("expected values %v, got %v", expect, seen)
+	}
+	for _, v := range expect {
+		if seen[v] < 100 {
+			t.Errorf("value %d chosen only %d times out of 1000", v, seen[v])
+		}
+	}
+
+	if _, err := expr.Random(10, 9); err == nil {
+		t.Errorf("expected error on empty range")
+	}
+	if _, err := expr.Random(8, 8); err == nil {
+		t.Errorf("expected error on range without matching integers")
+	}
+	all, err := ParseExpression("*")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if _, err := all.Random(math.MinInt, math.MaxInt); err != nil {
+		t.Errorf("unexpected error on full range: %s", err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	semicolon := DefaultParseOptions()
+	semicolon.Delimiter = ";"
+	hex := DefaultParseOptions()
+	hex.IntBase = IntBaseAuto
+	negation := DefaultParseOptions()
+	negation.AllowNegation = true
+	cases := []struct {
+		a, b         string
+		optsB        ParseOptions
+		equal        bool
+		equalWithOpt bool
+	}{
+		{a: "3-5,1,4", b: "1,3-5", optsB: DefaultParseOptions(), equal: true, equalWithOpt: true},
+		{a: "3-5,1,4", b: "1;3-5", optsB: semicolon, equal: true, equalWithOpt: false},
+		{a: "16-31", b: "0x10-0x1f", optsB: hex, equal: true, equalWithOpt: false},
+		{a: "1-4,6-10", b: "1-10,^5", optsB: negation, equal: true, equalWithOpt: false},
+		{a: "1,3-5", b: "1,3-6", optsB: DefaultParseOptions(), equal: false, equalWithOpt: false},
+		{a: "0-", b: "*", optsB: DefaultParseOptions(), equal: false, equalWithOpt: false},
+	}
+	for _, test := range cases {
+		a, err := ParseExpression(test.a)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		b, err := ParseExpressionWithOptions(test.b, test.optsB)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := a.Equal(b); got != test.equal {
+			t.Errorf("%q.Equal(%q): expected %v, got %v", test.a, test.b, test.equal, got)
+		}
+		if got := a.EqualWithOpts(b); got != test.equalWithOpt {
+			t.Errorf("%q.EqualWithOpts(%q): expected %v, got %v", test.a, test.b, test.equalWithOpt, got)
+		}
+	}
+
+	handler := func(string) {}
+	opts := DefaultParseOptions()
+	opts.WarnHandler = handler
+	a, err := ParseExpressionWithOptions("1", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	b, err := ParseExpressionWithOptions("1", opts)
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	if !a.EqualWithOpts(b) {
+		t.Errorf("expected options with the same WarnHandler to be equal")
+	}
+}
+
+func TestHasWildcard(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect bool
+	}{
+		{input: "1,*", expect: true},
+		{input: "*", expect: true},
+		{input: "0-", expect: false},
+		{input: "1-3", expect: false},
+		{input: "1-3,^*", expect: false},
+	}
+	opts := DefaultParseOptions()
+	opts.AllowNegation = true
+	for _, test := range cases {
+		expr, err := ParseExpressionWithOptions(test.input, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.HasWildcard(); got != test.expect {
+			t.Errorf("%q: expected %v, got %v", test.input, test.expect, got)
+		}
+	}
+}
+
+func TestWildcardCount(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect int
+	}{
+		{input: "1-3", expect: 0},
+		{input: "*", expect: 1},
+		{input: "*,1,*,5-,*", expect: 3},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.WildcardCount(); got != test.expect {
+			t.Errorf("%q: expected %d, got %d", test.input, test.expect, got)
+		}
+		if got := expr.Normalize().WildcardCount(); got > 1 {
+			t.Errorf("%q: expected at most one wildcard after Normalize(), got %d", test.input, got)
+		}
+	}
+	merged := Union(Expression{intervals: []subExpression{{matchAll: true}}}, Expression{intervals: []subExpression{{matchAll: true}}})
+	if got := merged.WildcardCount(); got != 1 {
+		t.Errorf("expected one wildcard after Union, got %d", got)
+	}
+}
+
+func TestOpenCount(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect int
+	}{
+		{input: "1-3", expect: 0},
+		{input: "1,7-", expect: 1},
+		{input: "9-,1,7-,*", expect: 2},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error from parser: %s", err)
+		}
+		if got := expr.OpenCount(); got != test.expect {
+			t.Errorf("%q: expected %d, got %d", test.input, test.expect, got)
+		}
+		if got := expr.Normalize().OpenCount(); got > 1 {
+			t.Errorf("%q: expected at most one open-ended subexpression after Normalize(), got %d", test.input, got)
+		}
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	var sb strings.Builder
+	n, err := expr.WriteTo(&sb)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if sb.String() != "1,3-5,7-" || n != int64(sb.Len()) {
+		t.Errorf("expected \"1,3-5,7-\" (%d bytes), got %q (%d bytes)", len("1,3-5,7-"), sb.String(), n)
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	var expr Expression
+	input := "1,3-5,7-\n"
+	n, err := expr.ReadFrom(io.MultiReader(strings.NewReader(input[:3]), strings.NewReader(input[3:])))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n != int64(len(input)) || expr.String() != "1,3-5,7-" {
+		t.Errorf("expected \"1,3-5,7-\" (%d bytes), got %q (%d bytes)", len(input), expr, n)
+	}
+
+	if _, err := expr.ReadFrom(strings.NewReader("5-3")); err == nil {
+		t.Errorf("expected error on invalid input")
+	}
+	if expr.String() != "1,3-5,7-" {
+		t.Errorf("expected Expression to be unchanged on error, got %q", expr)
+	}
+
+	var sb strings.Builder
+	if _, err := expr.WriteTo(&sb); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var again Expression
+	if _, err := again.ReadFrom(strings.NewReader(sb.String())); err != nil || !again.Equal(expr) {
+		t.Errorf("expected WriteTo/ReadFrom round-trip, got %q, %v", again, err)
+	}
+}
+
+func TestReduce(t *testing.T) {
+	expr, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	sum := ReduceInts(expr, func(acc, val int) int { return acc + val }, 0, 0, 9)
+	if expect := 1 + 3 + 4 + 5 + 7 + 8 + 9; sum != expect {
+		t.Errorf("expected sum %d, got %d", expect, sum)
+	}
+	s := ReduceInts(expr, func(acc string, val int) string { return acc + strconv.Itoa(val) }, ">", 4, 8)
+	if s != ">4578" {
+		t.Errorf("expected \">4578\", got %q", s)
+	}
+	count := expr.Reduce(func(acc interface{}, val int) interface{} { return acc.(int) + 1 }, 0, 0, 9)
+	if count != 7 {
+		t.Errorf("expected count 7, got %v", count)
+	}
+	if got := expr.Reduce(func(acc interface{}, val int) interface{} { return val }, "none", 10, 5); got != "none" {
+		t.Errorf("expected initial value for empty range, got %v", got)
+	}
+}
+
+func TestZeroValues(t *testing.T) {
+	cases := []struct {
+		input   string
+		matches []int
+		misses  []int
+	}{
+		{input: "0", matches: []int{0}, misses: []int{-1, 1}},
+		{input: "0-5", matches: []int{0, 3, 5}, misses: []int{-1, 6}},
+		{input: "0-", matches: []int{0, 1, math.MaxInt}, misses: []int{-1, math.MinInt}},
+	}
+	for _, test := range cases {
+		expr, err := ParseExpression(test.input)
+		if err != nil {
+			t.Fatalf("%q: unexpected error from parser: %s", test.input, err)
+		}
+		if got := expr.String(); got != test.input {
+			t.Errorf("%q: expected String() to round-trip, got %q", test.input, got)
+		}
+		for _, val := range test.matches {
+			if !expr.Matches(val) {
+				t.Errorf("%q: expected %d to match", test.input, val)
+			}
+		}
+		for _, val := range test.misses {
+			if expr.Matches(val) {
+				t.Errorf("%q: expected %d not to match", test.input, val)
+			}
+		}
+	}
+}
+
+func TestExpandTo(t *testing.T) {
+	a, err := ParseExpression("1,3-5,7-")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	b, err := ParseExpression("2,4")
+	if err != nil {
+		t.Fatalf("unexpected error from parser: %s", err)
+	}
+	got := a.ExpandTo(nil, 0, 8)
+	got = b.ExpandTo(got, 0, 8)
+	if expect := []int{1, 3, 4, 5, 7, 8, 2, 4}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	dst := make([]int, 1, 10)
+	dst[0] = -1
+	if got := b.ExpandTo(dst, 0, 3); !reflect.DeepEqual(got, []int{-1, 2}) || &got[0] != &dst[0] {
+		t.Errorf("expected values appended to dst, got %v", got)
+	}
+	if got := a.ExpandTo(nil, 5, 4); got != nil {
+		t.Errorf("expected nil for empty range, got %v", got)
+	}
+}